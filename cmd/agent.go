@@ -1,16 +1,95 @@
 package cmd
 
-import "github.com/spf13/cobra"
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/lyyyuna/gococo/pkg/agent"
+	"github.com/lyyyuna/gococo/pkg/log"
+	"github.com/spf13/cobra"
+)
 
 var agentCmd = &cobra.Command{
-	Use: "agent",
-	Run: agentAction,
+	Use:   "agent",
+	Short: "Talk to the agent of a running instrumented binary",
+}
+
+var agentProfileCmd = &cobra.Command{
+	Use:   "profile",
+	Short: "Get the current coverage profile",
+	Run:   agentProfileAction,
+}
+
+var agentResetCmd = &cobra.Command{
+	Use:   "reset",
+	Short: "Zero all the coverage counters",
+	Run:   agentResetAction,
+}
+
+var agentMetaCmd = &cobra.Command{
+	Use:   "meta",
+	Short: "Get the build metadata",
+	Run:   agentMetaAction,
+}
+
+var (
+	agentAddr    string
+	agentTimeout time.Duration
+	agentOutput  string
+)
+
+func agentProfileAction(cmd *cobra.Command, args []string) {
+	profile, err := newAgentClient().Profile(context.Background())
+	if err != nil {
+		log.Fatalf("fail to get the profile: %v", err)
+	}
+
+	if agentOutput == "" {
+		os.Stdout.Write(profile)
+		return
+	}
+
+	if err := os.WriteFile(agentOutput, profile, 0644); err != nil {
+		log.Fatalf("fail to write the profile: %v", err)
+	}
+	log.Donef("profile saved to: %v", agentOutput)
+}
+
+func agentResetAction(cmd *cobra.Command, args []string) {
+	if err := newAgentClient().Reset(context.Background()); err != nil {
+		log.Fatalf("fail to reset the counters: %v", err)
+	}
+	log.Donef("coverage counters reset")
 }
 
-func agentAction(cmd *cobra.Command, args []string) {
+func agentMetaAction(cmd *cobra.Command, args []string) {
+	meta, err := newAgentClient().Meta(context.Background())
+	if err != nil {
+		log.Fatalf("fail to get the metadata: %v", err)
+	}
 
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(meta)
+}
+
+func newAgentClient() *agent.Client {
+	if agentAddr == "" {
+		log.Fatalf("the agent address is not set, use --addr")
+	}
+
+	return agent.NewClient(agentAddr, agentTimeout)
 }
 
 func init() {
+	agentCmd.PersistentFlags().StringVar(&agentAddr, "addr", "", "the listen address of the agent")
+	agentCmd.PersistentFlags().DurationVar(&agentTimeout, "timeout", 10*time.Second, "the timeout of the request")
+	agentProfileCmd.Flags().StringVarP(&agentOutput, "output", "o", "", "save the profile to the file instead of stdout")
+
+	agentCmd.AddCommand(agentProfileCmd)
+	agentCmd.AddCommand(agentResetCmd)
+	agentCmd.AddCommand(agentMetaCmd)
 	rootCmd.AddCommand(agentCmd)
 }
@@ -1,21 +1,27 @@
 package cmd
 
 import (
+	"os"
+
 	"github.com/lyyyuna/gococo/pkg/compile"
 	"github.com/spf13/cobra"
 )
 
 var buildCmd = &cobra.Command{
 	Use:                "build",
+	Short:              "Like go build, with the coverage counters and the agent injected",
+	Long:               "Like go build, with the coverage counters and the agent injected.\n\nThe go flags are passed to the go command, the gococo flags are:\n\n" + compile.FlagsUsage(),
 	DisableFlagParsing: true,
 	Run:                buildAction,
 }
 
 func buildAction(cmd *cobra.Command, args []string) {
-	compile.NewCompile(
+	c := compile.NewCompile(
 		compile.WithBuild(),
 		compile.WithArgs(args),
 	)
+
+	os.Exit(c.ExitCode())
 }
 
 func init() {
@@ -1,15 +1,27 @@
 package cmd
 
-import "github.com/spf13/cobra"
+import (
+	"os"
+
+	"github.com/lyyyuna/gococo/pkg/compile"
+	"github.com/spf13/cobra"
+)
 
 var installCmd = &cobra.Command{
 	Use:                "install",
+	Short:              "Like go install, with the coverage counters and the agent injected",
+	Long:               "Like go install, with the coverage counters and the agent injected.\n\nThe go flags are passed to the go command, the gococo flags are:\n\n" + compile.FlagsUsage(),
 	DisableFlagParsing: true,
 	Run:                installAction,
 }
 
 func installAction(cmd *cobra.Command, args []string) {
+	c := compile.NewCompile(
+		compile.WithInstall(),
+		compile.WithArgs(args),
+	)
 
+	os.Exit(c.ExitCode())
 }
 
 func init() {
@@ -1,16 +1,134 @@
 package cmd
 
-import "github.com/spf13/cobra"
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/lyyyuna/gococo/pkg/cover"
+	"github.com/lyyyuna/gococo/pkg/log"
+	"github.com/lyyyuna/gococo/pkg/report"
+	"github.com/lyyyuna/gococo/pkg/server"
+	"github.com/spf13/cobra"
+)
 
 var reportCmd = &cobra.Command{
-	Use: "report",
-	Run: reportAction,
+	Use:   "report [profile]",
+	Short: "Render the coverage profile",
+	Long: `Render the coverage profile from a file or the gococo server.
+
+The file paths are resolved against the main modules of the project,
+so the report points at the real source files.`,
+	Args: cobra.MaximumNArgs(1),
+	Run:  reportAction,
 }
 
+var (
+	reportServer   string
+	reportServices []string
+	reportAddrs    []string
+	reportOutput   string
+	reportFormat   string
+	reportProject  string
+)
+
 func reportAction(cmd *cobra.Command, args []string) {
+	var raw []byte
+	switch {
+	case len(args) == 1 && reportServer != "":
+		log.Fatalf("either a profile file or --server can be specified, not both")
+	case len(args) == 1:
+		data, err := os.ReadFile(args[0])
+		if err != nil {
+			log.Fatalf("fail to read the profile: %v", err)
+		}
+		raw = data
+	case reportServer != "":
+		resp, err := server.NewClient(reportServer, time.Minute).Profile(context.Background(), reportServices, reportAddrs)
+		if err != nil {
+			log.Fatalf("fail to get the profile from the server: %v", err)
+		}
+		for _, res := range resp.Results {
+			if res.Error != "" {
+				log.Warnf("fail to collect the profile from %v (%v): %v", res.Instance.Name, res.Instance.Addr, res.Error)
+			}
+		}
+		raw = []byte(resp.Profile)
+	default:
+		log.Fatalf("no profile, specify a profile file or --server")
+	}
+
+	profiles, err := cover.ParseProfiles(bytes.NewReader(raw))
+	if err != nil {
+		log.Fatalf("fail to parse the profile: %v", err)
+	}
+
+	resolver, err := report.NewResolver(reportProject)
+	if err != nil {
+		log.Warnf("fail to resolve the source files: %v", err)
+	}
+	r := report.NewReport(profiles, resolver)
+
+	format := reportFormat
+	if format == "" {
+		format = formatOf(reportOutput)
+	}
+
+	var out io.Writer = os.Stdout
+	if reportOutput != "" {
+		f, err := os.Create(reportOutput)
+		if err != nil {
+			log.Fatalf("fail to create the output file: %v", err)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	switch format {
+	case "text":
+		err = r.WriteText(out)
+	case "html":
+		err = r.WriteHTML(out)
+	case "cobertura":
+		err = r.WriteCobertura(out)
+	case "lcov":
+		err = r.WriteLCOV(out)
+	default:
+		log.Fatalf("unknown report format: %v", format)
+	}
+	if err != nil {
+		log.Fatalf("fail to write the report: %v", err)
+	}
+
+	if reportOutput != "" {
+		log.Donef("report saved to: %v", reportOutput)
+	}
+}
 
+// formatOf infers the report format from the output file extension
+func formatOf(output string) string {
+	switch strings.ToLower(filepath.Ext(output)) {
+	case ".html", ".htm":
+		return "html"
+	case ".xml":
+		return "cobertura"
+	case ".info", ".lcov":
+		return "lcov"
+	default:
+		return "text"
+	}
 }
 
 func init() {
+	reportCmd.Flags().StringVar(&reportServer, "server", "", "pull the merged profile from the gococo server")
+	reportCmd.Flags().StringSliceVar(&reportServices, "service", nil, "only collect the instances of the services, used with --server")
+	reportCmd.Flags().StringSliceVar(&reportAddrs, "address", nil, "only collect the instances of the agent addresses, used with --server")
+	reportCmd.Flags().StringVarP(&reportOutput, "output", "o", "", "save the report to the file instead of stdout")
+	reportCmd.Flags().StringVar(&reportFormat, "format", "", "the report format: text, html, cobertura or lcov, inferred from the output file by default")
+	reportCmd.Flags().StringVar(&reportProject, "project", ".", "the project directory to resolve the source files")
 	rootCmd.AddCommand(reportCmd)
 }
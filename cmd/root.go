@@ -7,6 +7,8 @@ import (
 	"github.com/spf13/cobra"
 )
 
+var logFormat string
+
 var rootCmd = &cobra.Command{
 	Use:   "gococo",
 	Short: "gococo is a Go Coverage Collection tool",
@@ -16,11 +18,25 @@ var rootCmd = &cobra.Command{
 			debug = true
 		}
 		log.NewLogger(debug)
+
+		format := logFormat
+		if format == "" {
+			format = os.Getenv("GOCOCO_LOG_FORMAT")
+		}
+		if format != "" {
+			if err := log.SetFormat(format); err != nil {
+				log.Fatalf("%v", err)
+			}
+		}
 	},
 	PersistentPostRun: func(cmd *cobra.Command, args []string) {
 	},
 }
 
+func init() {
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", "", "the log format: text or json, or GOCOCO_LOG_FORMAT, use --gococo-log-format for build, install and run")
+}
+
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
 
@@ -1,16 +1,51 @@
 package cmd
 
-import "github.com/spf13/cobra"
+import (
+	"context"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/lyyyuna/gococo/pkg/log"
+	"github.com/lyyyuna/gococo/pkg/server"
+	"github.com/spf13/cobra"
+)
 
 var serverCmd = &cobra.Command{
-	Use: "server",
-	Run: serverAction,
+	Use:   "server",
+	Short: "Start a central server which the instrumented binaries register with",
+	Run:   serverAction,
 }
 
+var (
+	serverListen         string
+	serverTTL            time.Duration
+	serverCollectTimeout time.Duration
+)
+
 func serverAction(cmd *cobra.Command, args []string) {
+	if serverTTL < server.MIN_TTL {
+		log.Fatalf("invalid --ttl %v, should be at least %v", serverTTL, server.MIN_TTL)
+	}
+	if serverCollectTimeout <= 0 {
+		log.Fatalf("invalid --collect-timeout %v, should be positive", serverCollectTimeout)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
 
+	s := server.NewServer(
+		server.WithTTL(serverTTL),
+		server.WithCollectTimeout(serverCollectTimeout),
+	)
+	if err := s.Run(ctx, serverListen); err != nil {
+		log.Fatalf("fail to run the server: %v", err)
+	}
 }
 
 func init() {
+	serverCmd.Flags().StringVar(&serverListen, "listen", ":7777", "the listen address of the server")
+	serverCmd.Flags().DurationVar(&serverTTL, "ttl", server.DEFAULT_TTL, "evict the instance if no heartbeat is received within the ttl")
+	serverCmd.Flags().DurationVar(&serverCollectTimeout, "collect-timeout", server.DEFAULT_COLLECT_TIMEOUT, "the default timeout to collect the profile from one instance")
 	rootCmd.AddCommand(serverCmd)
 }
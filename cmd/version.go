@@ -3,6 +3,7 @@ package cmd
 import (
 	"fmt"
 
+	"github.com/lyyyuna/gococo/pkg/version"
 	"github.com/spf13/cobra"
 )
 
@@ -12,7 +13,7 @@ var versionCmd = &cobra.Command{
 }
 
 func versionAction(cmd *cobra.Command, args []string) {
-	fmt.Println("0.0.1")
+	fmt.Println(version.Version)
 }
 
 func init() {
@@ -0,0 +1,99 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// the http api exposed by the agent injected into the instrumented binary
+const (
+	PROFILE_API = "/v1/cover/profile"
+	RESET_API   = "/v1/cover/reset"
+	META_API    = "/v1/meta"
+)
+
+// Meta is the build metadata of an instrumented binary
+type Meta struct {
+	ModulePath string `json:"modulePath"`
+	BuildTags  string `json:"buildTags"`
+	CoverMode  string `json:"coverMode"`
+	Version    string `json:"version"`
+	BuildTime  string `json:"buildTime"`
+	Pid        int    `json:"pid"`
+}
+
+// Client talks to the agent of a running instrumented binary
+type Client struct {
+	addr       string
+	httpClient *http.Client
+}
+
+// NewClient creates a client for the agent listening on addr,
+// the addr can be either `host:port` or a http url.
+func NewClient(addr string, timeout time.Duration) *Client {
+	if !strings.HasPrefix(addr, "http://") && !strings.HasPrefix(addr, "https://") {
+		addr = "http://" + addr
+	}
+
+	return &Client{
+		addr: strings.TrimSuffix(addr, "/"),
+		httpClient: &http.Client{
+			Timeout: timeout,
+		},
+	}
+}
+
+// Profile returns the current coverage profile of the agent
+func (c *Client) Profile(ctx context.Context) ([]byte, error) {
+	return c.do(ctx, http.MethodGet, PROFILE_API)
+}
+
+// Reset zeroes all the coverage counters of the agent
+func (c *Client) Reset(ctx context.Context) error {
+	_, err := c.do(ctx, http.MethodPost, RESET_API)
+	return err
+}
+
+// Meta returns the build metadata of the agent
+func (c *Client) Meta(ctx context.Context) (*Meta, error) {
+	body, err := c.do(ctx, http.MethodGet, META_API)
+	if err != nil {
+		return nil, err
+	}
+
+	var meta Meta
+	if err := json.Unmarshal(body, &meta); err != nil {
+		return nil, fmt.Errorf("fail to decode the metadata: %w", err)
+	}
+
+	return &meta, nil
+}
+
+func (c *Client) do(ctx context.Context, method string, api string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.addr+api, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("fail to read the response of %v: %w", api, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%v %v returns %v: %v", method, api, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	return body, nil
+}
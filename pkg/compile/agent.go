@@ -0,0 +1,512 @@
+package compile
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"text/template"
+	"time"
+
+	"github.com/lyyyuna/gococo/pkg/agent"
+	"github.com/lyyyuna/gococo/pkg/log"
+	"github.com/lyyyuna/gococo/pkg/server"
+	"github.com/lyyyuna/gococo/pkg/version"
+)
+
+const (
+	// AGENT_PACKAGE_DIR is the directory of the generated agent package, relative to the module root
+	AGENT_PACKAGE_DIR = "gococoagent"
+
+	// AGENT_MAIN_FILE is the generated file in every main package to start the agent
+	AGENT_MAIN_FILE = "zz_gococo_agent.go"
+
+	// AGENT_REGISTER_FILE is the generated file in every instrumented package to register the counters
+	AGENT_REGISTER_FILE = "zz_gococo_register.go"
+)
+
+// injectAgent drops the agent package into the cache, registers the coverage variables of
+// every instrumented package to the agent, and starts the agent in every main package.
+func (c *Compile) injectAgent() {
+	agentImportPath := path.Join(c.projectModulePath, AGENT_PACKAGE_DIR)
+	if _, ok := c.pkgs[agentImportPath]; ok {
+		log.Fatalf("the package %v conflicts with the gococo agent package", agentImportPath)
+	}
+
+	// the agent package may be put in another go.work member by the last build
+	if c.isWorkRoot {
+		for dir := range c.workDirs {
+			if dir == c.projectModuleDir {
+				continue
+			}
+			if err := os.RemoveAll(filepath.Join(c.cachedPath(dir), AGENT_PACKAGE_DIR)); err != nil {
+				log.Fatalf("fail to remove the old agent package: %v", err)
+			}
+		}
+	}
+
+	agentDir := filepath.Join(c.cachedPath(c.projectModuleDir), AGENT_PACKAGE_DIR)
+	writeTemplate(filepath.Join(agentDir, "agent.go"), agentTmpl, map[string]interface{}{
+		"ModulePath": c.projectModulePath,
+		"BuildTags":  c.buildTags,
+		"CoverMode":  c.coverMode,
+		"AgentAddr":  c.agentAddr,
+		"Server":     c.serverAddr,
+		"Version":    version.Version,
+		"BuildTime":  time.Now().Format(time.RFC3339),
+		"ProfileAPI": agent.PROFILE_API,
+		"ResetAPI":   agent.RESET_API,
+		"MetaAPI":    agent.META_API,
+
+		"RegisterAPI":  server.REGISTER_API,
+		"HeartbeatAPI": server.HEARTBEAT_API,
+	})
+	c.writeNativeAgent(agentDir)
+
+	cmdlineDir, cmdlineFiles := c.cmdlineFiles()
+	for _, pkgCover := range c.pkgCovers {
+		files := make([]string, 0, len(pkgCover.Vars))
+		for file := range pkgCover.Vars {
+			// only the go files in the command line are built with the register file of their directory
+			if _, ok := cmdlineFiles[file]; !ok && pkgCover.Package.Dir == cmdlineDir {
+				continue
+			}
+			files = append(files, file)
+		}
+		sort.Strings(files)
+
+		registerFile := filepath.Join(c.cachedPath(pkgCover.Package.Dir), AGENT_REGISTER_FILE)
+		if len(files) == 0 {
+			if err := os.Remove(registerFile); err != nil && !os.IsNotExist(err) {
+				log.Fatalf("fail to remove %v: %v", registerFile, err)
+			}
+			continue
+		}
+
+		vars := make([]*FileVar, 0, len(files))
+		for _, file := range files {
+			vars = append(vars, pkgCover.Vars[file])
+		}
+
+		writeTemplate(registerFile, registerTmpl, map[string]interface{}{
+			"Package":         pkgCover.Package.Name,
+			"AgentImportPath": agentImportPath,
+			"Vars":            vars,
+		})
+	}
+
+	mains := 0
+	for _, pkg := range c.pkgs {
+		if pkg.Name != "main" || pkg.Module == nil || !pkg.Module.Main {
+			continue
+		}
+
+		writeTemplate(filepath.Join(c.cachedPath(pkg.Dir), AGENT_MAIN_FILE), mainTmpl, map[string]interface{}{
+			"AgentImportPath": agentImportPath,
+			"WrapMain":        c.wrapMain(pkg),
+			"MainWrapped":     MAIN_WRAPPED,
+		})
+		mains++
+	}
+
+	log.Donef("agent injected into %v main packages", mains)
+}
+
+// MAIN_WRAPPED is the new name of the original main function,
+// the generated main function flushes the profile after it returns.
+//
+// it is as long as main, not to shift the positions seen by `go build -cover`.
+const MAIN_WRAPPED = "_gm_"
+
+// legacyMainWrapped is the name used by the older gococo, it may be left in the cache
+const legacyMainWrapped = "_gococo_main_"
+
+// wrapMain renames the main function of the main package in the cache,
+// it is safe to call it on a package which is already wrapped.
+//
+// it returns false if the main function is not found.
+func (c *Compile) wrapMain(pkg *Package) bool {
+	files := append(append([]string{}, pkg.GoFiles...), pkg.CgoFiles...)
+	for _, file := range files {
+		if c.overlaid(filepath.Join(pkg.Dir, file)) {
+			continue
+		}
+
+		cachedFile := filepath.Join(c.cachedPath(pkg.Dir), file)
+		src, err := os.ReadFile(cachedFile)
+		if err != nil {
+			log.Fatalf("fail to read %v: %v", cachedFile, err)
+		}
+
+		fset := token.NewFileSet()
+		f, err := parser.ParseFile(fset, cachedFile, src, parser.SkipObjectResolution)
+		if err != nil {
+			log.Fatalf("fail to parse %v: %v", cachedFile, err)
+		}
+
+		for _, decl := range f.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Recv != nil {
+				continue
+			}
+
+			switch fn.Name.Name {
+			case MAIN_WRAPPED:
+				return true
+			case "main", legacyMainWrapped:
+				offset := fset.File(fn.Name.Pos()).Offset(fn.Name.Pos())
+				out := make([]byte, 0, len(src)+len(MAIN_WRAPPED))
+				out = append(out, src[:offset]...)
+				out = append(out, MAIN_WRAPPED...)
+				out = append(out, src[offset+len(fn.Name.Name):]...)
+				// the file may be hard linked to the original by an older version
+				if err := prepareDst(cachedFile); err != nil {
+					log.Fatalf("fail to write %v: %v", cachedFile, err)
+				}
+				if err := os.WriteFile(cachedFile, out, 0644); err != nil {
+					log.Fatalf("fail to write %v: %v", cachedFile, err)
+				}
+				log.Debugf("main function wrapped in: %v", cachedFile)
+				return true
+			}
+		}
+	}
+
+	log.Warnf("main function is not found in package %v, the profile will not be flushed on exit", pkg.ImportPath)
+	return false
+}
+
+// writeTemplate renders the template to the file
+func writeTemplate(file string, tmpl *template.Template, data interface{}) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		log.Fatalf("fail to render the template %v: %v", tmpl.Name(), err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(file), os.ModePerm); err != nil {
+		log.Fatalf("fail to create the directory for %v: %v", file, err)
+	}
+
+	if err := os.WriteFile(file, buf.Bytes(), 0644); err != nil {
+		log.Fatalf("fail to write the file %v: %v", file, err)
+	}
+}
+
+var registerTmpl = template.Must(template.New("register").Parse(`// Code generated by gococo. DO NOT EDIT.
+
+package {{.Package}}
+
+import _gococo_agent_ "{{.AgentImportPath}}"
+
+func init() {
+{{- range .Vars}}
+	_gococo_agent_.Register("{{.File}}", {{.Var}}.Count[:], {{.Var}}.Pos[:], {{.Var}}.NumStmt[:])
+{{- end}}
+}
+`))
+
+var mainTmpl = template.Must(template.New("main").Parse(`// Code generated by gococo. DO NOT EDIT.
+
+package main
+
+import _gococo_agent_ "{{.AgentImportPath}}"
+
+func init() {
+	_gococo_agent_.Start()
+}
+{{- if .WrapMain}}
+
+func main() {
+	defer _gococo_agent_.Flush()
+	{{.MainWrapped}}()
+}
+{{- end}}
+`))
+
+var agentTmpl = template.Must(template.New("agent").Parse(`// Code generated by gococo. DO NOT EDIT.
+
+// Package gococoagent holds the coverage counters of the instrumented packages,
+// and exposes them through a lightweight http listener.
+package gococoagent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// the build metadata
+const (
+	modulePath = {{printf "%q" .ModulePath}}
+	buildTags  = {{printf "%q" .BuildTags}}
+	coverMode  = {{printf "%q" .CoverMode}}
+	version    = {{printf "%q" .Version}}
+	buildTime  = {{printf "%q" .BuildTime}}
+)
+
+// the default addresses set at build time, the environment variables override them
+const (
+	defaultAgentAddr = {{printf "%q" .AgentAddr}}
+	defaultServer    = {{printf "%q" .Server}}
+)
+
+type fileCover struct {
+	file    string
+	count   []uint32
+	pos     []uint32
+	numStmt []uint16
+}
+
+var (
+	mu    sync.Mutex
+	files []*fileCover
+
+	startOnce sync.Once
+)
+
+// the counters are read by runtime/coverage instead if built with -cover, see native.go
+var (
+	nativeProfile func(w io.Writer) error
+	nativeReset   func() error
+)
+
+// Register registers the coverage counters of one instrumented file
+func Register(file string, count []uint32, pos []uint32, numStmt []uint16) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	files = append(files, &fileCover{
+		file:    file,
+		count:   count,
+		pos:     pos,
+		numStmt: numStmt,
+	})
+}
+
+// heartbeatInterval is the interval to send heartbeat to the gococo server,
+// unless the server tells another one when registering
+const heartbeatInterval = 10 * time.Second
+
+// Start starts the agent http listener in the background,
+// the listen address can be changed by the GOCOCO_AGENT_ADDR environment variable.
+//
+// if the GOCOCO_SERVER environment variable is set, the agent registers with the gococo server.
+// both default to the addresses set at build time. without the listen address, the agent listens
+// on the loopback interface, or on all the interfaces if it registers with the server, so that the
+// server can reach it.
+func Start() {
+	startOnce.Do(func() {
+		server := os.Getenv("GOCOCO_SERVER")
+		if server == "" {
+			server = defaultServer
+		}
+
+		addr := os.Getenv("GOCOCO_AGENT_ADDR")
+		if addr == "" {
+			addr = defaultAgentAddr
+		}
+		if addr == "" && server != "" {
+			addr = ":0"
+		}
+		if addr == "" {
+			addr = "127.0.0.1:0"
+		}
+
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[gococo] fail to start the agent: %v\n", err)
+			return
+		}
+		fmt.Fprintf(os.Stderr, "[gococo] agent listening on: %v\n", ln.Addr())
+
+		mux := http.NewServeMux()
+		mux.HandleFunc({{printf "%q" .ProfileAPI}}, profileHandler)
+		mux.HandleFunc({{printf "%q" .ResetAPI}}, resetHandler)
+		mux.HandleFunc({{printf "%q" .MetaAPI}}, metaHandler)
+
+		go http.Serve(ln, mux)
+
+		if server != "" {
+			go registerLoop(server, ln.Addr().String())
+		}
+	})
+}
+
+func profileHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	writeProfile(w)
+}
+
+func resetHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := reset(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func metaHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"modulePath": modulePath,
+		"buildTags":  buildTags,
+		"coverMode":  coverMode,
+		"version":    version,
+		"buildTime":  buildTime,
+		"pid":        os.Getpid(),
+	})
+}
+
+// Flush writes the profile to the file set by the GOCOCO_COVERPROFILE environment variable,
+// it is called after the main function returns.
+func Flush() {
+	file := os.Getenv("GOCOCO_COVERPROFILE")
+	if file == "" {
+		return
+	}
+
+	f, err := os.Create(file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[gococo] fail to create the profile: %v\n", err)
+		return
+	}
+	defer f.Close()
+
+	writeProfile(f)
+}
+
+// registerLoop registers the agent with the gococo server, and keeps sending heartbeat.
+// it registers again if the server does not know the agent any more, e.g., the server restarts.
+func registerLoop(server string, addr string) {
+	if !strings.HasPrefix(server, "http://") && !strings.HasPrefix(server, "https://") {
+		server = "http://" + server
+	}
+	server = strings.TrimSuffix(server, "/")
+
+	name := os.Getenv("GOCOCO_SERVICE_NAME")
+	if name == "" {
+		name = strings.TrimSuffix(filepath.Base(os.Args[0]), ".exe")
+	}
+	host, _ := os.Hostname()
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	post := func(api string, v interface{}) (*http.Response, error) {
+		body, _ := json.Marshal(v)
+		return client.Post(server+api, "application/json", bytes.NewReader(body))
+	}
+
+	id := ""
+	interval := heartbeatInterval
+	for {
+		if id == "" {
+			resp, err := post({{printf "%q" .RegisterAPI}}, map[string]interface{}{
+				"name": name,
+				"host": host,
+				"pid":  os.Getpid(),
+				"addr": addr,
+			})
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[gococo] fail to register with the server: %v\n", err)
+			} else {
+				var ins struct {
+					ID                string
+					HeartbeatInterval time.Duration
+				}
+				if resp.StatusCode == http.StatusOK && json.NewDecoder(resp.Body).Decode(&ins) == nil {
+					id = ins.ID
+					if ins.HeartbeatInterval > 0 {
+						interval = ins.HeartbeatInterval
+					}
+				} else {
+					fmt.Fprintf(os.Stderr, "[gococo] fail to register with the server: %v\n", resp.Status)
+				}
+				resp.Body.Close()
+			}
+		} else {
+			resp, err := post({{printf "%q" .HeartbeatAPI}}, map[string]interface{}{
+				"id": id,
+			})
+			if err == nil {
+				if resp.StatusCode == http.StatusNotFound {
+					id = ""
+					resp.Body.Close()
+					continue
+				}
+				resp.Body.Close()
+			}
+		}
+
+		time.Sleep(interval)
+	}
+}
+
+// writeProfile writes the current counters in the go coverage profile format
+func writeProfile(w io.Writer) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	fmt.Fprintf(w, "mode: %s\n", coverMode)
+	if nativeProfile != nil {
+		if err := nativeProfile(w); err != nil {
+			fmt.Fprintf(os.Stderr, "[gococo] fail to read the coverage counters: %v\n", err)
+		}
+		return
+	}
+
+	for _, f := range files {
+		for i := range f.count {
+			fmt.Fprintf(w, "%s:%d.%d,%d.%d %d %d\n", f.file,
+				f.pos[3*i+0], uint16(f.pos[3*i+2]),
+				f.pos[3*i+1], uint16(f.pos[3*i+2]>>16),
+				f.numStmt[i],
+				atomic.LoadUint32(&f.count[i]))
+		}
+	}
+}
+
+// reset zeroes all the counters, the profile is never written in the middle of a reset
+func reset() error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if nativeReset != nil {
+		return nativeReset()
+	}
+
+	for _, f := range files {
+		for i := range f.count {
+			atomic.StoreUint32(&f.count[i], 0)
+		}
+	}
+
+	return nil
+}
+`))
@@ -2,7 +2,13 @@ package compile
 
 import (
 	"flag"
+	"fmt"
+	"os"
+	"os/exec"
 	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
 
 	"github.com/lyyyuna/gococo/pkg/log"
 )
@@ -11,6 +17,20 @@ import (
 func (c *Compile) parseArgs() {
 	var goflags goConfig
 
+	// for run, the arguments after `--` are passed to the program
+	oriArgs := c.oriArgs
+	dashDash := false
+	if c.compileType == GOCOCO_DO_RUN {
+		for i, arg := range oriArgs {
+			if arg == "--" {
+				c.programArgs = oriArgs[i+1:]
+				oriArgs = oriArgs[:i]
+				dashDash = true
+				break
+			}
+		}
+	}
+
 	addBuildFlags := func(cmdSet *flag.FlagSet) {
 		cmdSet.BoolVar(&goflags.BuildA, "a", false, "")
 		cmdSet.BoolVar(&goflags.BuildN, "n", false, "")
@@ -39,6 +59,16 @@ func (c *Compile) parseArgs() {
 		cmdSet.StringVar(&goflags.BuildToolexec, "toolexec", "", "")
 		cmdSet.BoolVar(&goflags.BuildTrimpath, "trimpath", false, "")
 		cmdSet.BoolVar(&goflags.BuildWork, "work", false, "")
+		// added after go 1.19
+		cmdSet.StringVar(&goflags.BuildC, "C", "", "")
+		cmdSet.BoolVar(&goflags.BuildASan, "asan", false, "")
+		cmdSet.Var(&goflags.BuildVCS, "buildvcs", "")
+		cmdSet.BoolVar(&goflags.BuildCover, "cover", false, "")
+		cmdSet.StringVar(&goflags.BuildCoverPkg, "coverpkg", "", "")
+		cmdSet.StringVar(&goflags.BuildCoverMode, "covermode", "", "")
+		cmdSet.BoolVar(&goflags.BuildJSON, "json", false, "")
+		cmdSet.StringVar(&goflags.BuildOverlay, "overlay", "", "")
+		cmdSet.StringVar(&goflags.BuildPGO, "pgo", "", "")
 	}
 
 	addOutputFlags := func(cmdSet *flag.FlagSet) {
@@ -48,38 +78,243 @@ func (c *Compile) parseArgs() {
 	goFlagSets := flag.NewFlagSet("GO jiayi shi tiancai !!!", flag.ContinueOnError)
 	addBuildFlags(goFlagSets)
 	addOutputFlags(goFlagSets)
-	err := goFlagSets.Parse(c.oriArgs)
+
+	// the flags of gococo itself are not known by the go command
+	oriArgs = c.parseGococoFlags(oriArgs, goFlagSets)
+	c.resolveBackend()
+
+	// the flags unknown by gococo, e.g., added by a newer go, are passed through as they are
+	oriArgs, unknownFlags, err := splitUnknownFlags(goFlagSets, oriArgs, toolchainFlags)
 	if err != nil {
 		log.Fatalf("%v", err)
 	}
+	if err := goFlagSets.Parse(oriArgs); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	// like go, change to the directory before anything else
+	if goflags.BuildC != "" {
+		dir := absPath(c.curWd, goflags.BuildC)
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			log.Fatalf("-C flag is not a valid directory: %v", goflags.BuildC)
+		}
+		c.curWd = dir
+	}
+	c.readTarget()
 
 	// check if -o is set
 	var oset bool
 	flags := make([]string, 0)
 	goFlagSets.Visit(func(f *flag.Flag) {
-		// turn the output dir to absolute path, as we will do compile in a temporary dir
-		if f.Name == "o" {
-			outputDir := f.Value.String()
-			outputDir, err := filepath.Abs(outputDir)
-			if err != nil {
-				log.Fatalf("output flag is not valid: %v", err)
-			}
-			flags = append(flags, "-o", outputDir)
+		// turn the paths to absolute paths, as we will do compile in a temporary dir
+		switch f.Name {
+		case "C":
+			// the go command runs in the corresponding directory in the cache
+		case "o":
+			// build emits -o in resolveOutput
+			c.outputFlag = f.Value.String()
 			oset = true
-		} else {
-			flags = append(flags, "-"+f.Name, f.Value.String())
+		case "overlay":
+			// the overlay is rewritten for the cache, see writeOverlay
+			c.readOverlay(absPath(c.curWd, f.Value.String()))
+		case "modfile", "pkgdir":
+			flags = append(flags, "-"+f.Name+"="+absPath(c.curWd, f.Value.String()))
+		case "pgo":
+			pgo := f.Value.String()
+			if pgo != "auto" && pgo != "off" && pgo != "" {
+				pgo = absPath(c.curWd, pgo)
+			}
+			flags = append(flags, "-pgo="+pgo)
+		case "cover", "covermode", "coverpkg":
+			// the native backend emits its own -cover flags, see nativeCoverFlags
+			if c.backend != BACKEND_NATIVE {
+				flags = append(flags, "-"+f.Name+"="+f.Value.String())
+			}
+		default:
+			// the bool flag only accepts the -name=value form
+			flags = append(flags, "-"+f.Name+"="+f.Value.String())
 		}
 	})
+	flags = append(flags, unknownFlags...)
+
+	if c.backend == BACKEND_NATIVE {
+		c.mergeCoverFlags(goflags.BuildCover, goflags.BuildCoverMode, goflags.BuildCoverPkg)
+	} else if goflags.BuildCover || goflags.BuildCoverPkg != "" {
+		log.Warnf("-cover instruments the packages again on top of gococo, they may conflict")
+	}
+
+	args := goFlagSets.Args()
+
+	if oset && c.compileType == GOCOCO_DO_INSTALL {
+		log.Fatalf("-o is not allowed in install")
+	}
 
-	// if -o is not set, output the binary to the orignal working directory
-	if !oset && c.compileType == GOCOCO_DO_BUILD {
-		flags = append(flags, "-o", c.curWd)
+	// for run, output the binary to a temporary directory
+	if c.compileType == GOCOCO_DO_RUN {
+		if oset {
+			log.Fatalf("-o is not allowed in run")
+		}
+
+		// like `go run`, without `--`, the arguments after the package are passed to the program
+		if !dashDash {
+			args, c.programArgs = splitRunArgs(args)
+		}
+
+		tmpDir, err := os.MkdirTemp("", "gococo-run-")
+		if err != nil {
+			log.Fatalf("fail to create the temporary directory: %v", err)
+		}
+		c.runBinary = filepath.Join(tmpDir, "gococo-run") + c.target.exeSuffix()
+		if c.target.cross() {
+			log.Warnf("the program is built for %v/%v, it may not run on this machine", c.target.GOOS, c.target.GOARCH)
+		}
+		flags = append(flags, "-o", c.runBinary)
 	}
 
 	c.modifiedFlags = flags
-	c.modifedArgs = goFlagSets.Args()
+	c.modifedArgs = args
 	c.buildTags = goflags.BuildTags
 	c.buildMod = goflags.BuildMod
+
+	// the flags that change the go files selected by the build constraints,
+	// and -mod, which changes where the packages are loaded from
+	if goflags.BuildMod != "" {
+		c.listFlags = append(c.listFlags, "-mod="+goflags.BuildMod)
+	}
+	if goflags.BuildTags != "" {
+		c.listFlags = append(c.listFlags, "-tags", goflags.BuildTags)
+	}
+	if goflags.BuildRace {
+		c.listFlags = append(c.listFlags, "-race")
+	}
+	if goflags.BuildMSan {
+		c.listFlags = append(c.listFlags, "-msan")
+	}
+	if goflags.BuildASan {
+		c.listFlags = append(c.listFlags, "-asan")
+	}
+}
+
+// splitUnknownFlags splits the flags not defined in the flag set out of the arguments,
+// until the first non-flag argument. the unknown flag must be a build flag of the installed go command,
+// which tells if it takes a value.
+func splitUnknownFlags(fs *flag.FlagSet, args []string, toolchain func() map[string]bool) ([]string, []string, error) {
+	known := make([]string, 0, len(args))
+	unknown := make([]string, 0)
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		name, _, hasValue := splitFlag(arg)
+		if name == "" || arg == "--" {
+			known = append(known, args[i:]...)
+			break
+		}
+
+		f := fs.Lookup(name)
+		if f == nil {
+			takesValue, ok := toolchain()[name]
+			if !ok {
+				return nil, nil, fmt.Errorf("flag provided but not defined: -%v", name)
+			}
+
+			log.Debugf("unknown go flag %v is passed through", arg)
+			unknown = append(unknown, arg)
+			if takesValue && !hasValue {
+				if i+1 >= len(args) {
+					return nil, nil, fmt.Errorf("flag needs an argument: -%v", name)
+				}
+				i++
+				unknown = append(unknown, args[i])
+			}
+			continue
+		}
+
+		known = append(known, arg)
+		if !hasValue && !isBoolFlag(f) && i+1 < len(args) {
+			i++
+			known = append(known, args[i])
+		}
+	}
+
+	return known, unknown, nil
+}
+
+// buildFlagRe matches the flag in the output of `go help build`, like `\t-p n` or `\t-race`
+var buildFlagRe = regexp.MustCompile(`^\t-([A-Za-z0-9_-]+)( .*)?$`)
+
+var (
+	toolchainFlagsOnce sync.Once
+	toolchainFlagsMap  map[string]bool
+)
+
+// toolchainFlags returns the build flags of the installed go command, read from `go help build` once,
+// the value tells if the flag takes a value.
+func toolchainFlags() map[string]bool {
+	toolchainFlagsOnce.Do(func() {
+		out, err := exec.Command("go", "help", "build").Output()
+		if err != nil {
+			log.Fatalf("fail to read the build flags of the go command: %v", err)
+		}
+		toolchainFlagsMap = parseBuildFlags(string(out))
+	})
+
+	return toolchainFlagsMap
+}
+
+// parseBuildFlags parses the flags in the output of `go help build`
+func parseBuildFlags(help string) map[string]bool {
+	flags := make(map[string]bool)
+	for _, line := range strings.Split(help, "\n") {
+		if m := buildFlagRe.FindStringSubmatch(strings.TrimRight(line, "\r")); m != nil {
+			flags[m[1]] = m[2] != ""
+		}
+	}
+
+	return flags
+}
+
+// goFlagTakesValue tells if the go flag takes a value, by gococo or the installed go command
+func goFlagTakesValue(fs *flag.FlagSet, name string) bool {
+	if f := fs.Lookup(name); f != nil {
+		return !isBoolFlag(f)
+	}
+
+	return toolchainFlags()[name]
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// boolOrString is a bool flag which accepts other values too, like -buildvcs=auto
+type boolOrString string
+
+func (b *boolOrString) String() string {
+	return string(*b)
+}
+
+func (b *boolOrString) Set(v string) error {
+	*b = boolOrString(v)
+	return nil
+}
+
+func (b *boolOrString) IsBoolFlag() bool {
+	return true
+}
+
+// splitRunArgs splits the arguments of run into the package and the program arguments,
+// the package is either a list of .go files or one package pattern.
+func splitRunArgs(args []string) ([]string, []string) {
+	i := 0
+	for i < len(args) && strings.HasSuffix(args[i], ".go") {
+		i++
+	}
+
+	if i == 0 && len(args) != 0 {
+		i = 1
+	}
+
+	return args[:i], args[i:]
 }
 
 type goConfig struct {
@@ -116,4 +351,15 @@ type goConfig struct {
 	// mod related
 	ModCacheRW bool
 	ModFile    string
+
+	// added after go 1.19
+	BuildC         string       // -C flag
+	BuildASan      bool         // -asan flag
+	BuildVCS       boolOrString // -buildvcs flag
+	BuildCover     bool         // -cover flag
+	BuildCoverPkg  string       // -coverpkg flag
+	BuildCoverMode string       // -covermode flag
+	BuildJSON      bool         // -json flag
+	BuildOverlay   string       // -overlay flag
+	BuildPGO       string       // -pgo flag
 }
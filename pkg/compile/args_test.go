@@ -0,0 +1,203 @@
+package compile
+
+import (
+	"flag"
+	"reflect"
+	"testing"
+)
+
+// newTestGoFlagSet defines a few go flags, a bool one and a string one
+func newTestGoFlagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("go", flag.ContinueOnError)
+	fs.Bool("race", false, "")
+	fs.String("tags", "", "")
+	fs.String("o", "", "")
+
+	return fs
+}
+
+func TestSplitUnknownFlags(t *testing.T) {
+	toolchain := func() map[string]bool {
+		return map[string]bool{
+			"newbool":  false,
+			"newvalue": true,
+		}
+	}
+
+	tests := []struct {
+		name        string
+		args        []string
+		wantKnown   []string
+		wantUnknown []string
+		wantErr     bool
+	}{
+		{
+			name:        "known flags",
+			args:        []string{"-race", "-tags", "a,b", "-o=out", "./cmd"},
+			wantKnown:   []string{"-race", "-tags", "a,b", "-o=out", "./cmd"},
+			wantUnknown: []string{},
+		},
+		{
+			name:        "unknown bool flag",
+			args:        []string{"-newbool", "-race", "."},
+			wantKnown:   []string{"-race", "."},
+			wantUnknown: []string{"-newbool"},
+		},
+		{
+			name:        "unknown flag with a separate value",
+			args:        []string{"-newvalue", "v", "-tags", "t", "."},
+			wantKnown:   []string{"-tags", "t", "."},
+			wantUnknown: []string{"-newvalue", "v"},
+		},
+		{
+			name:        "unknown flag with an inline value",
+			args:        []string{"--newvalue=v", "."},
+			wantKnown:   []string{"."},
+			wantUnknown: []string{"--newvalue=v"},
+		},
+		{
+			name:        "stops at the first argument",
+			args:        []string{"-race", ".", "-newbool"},
+			wantKnown:   []string{"-race", ".", "-newbool"},
+			wantUnknown: []string{},
+		},
+		{
+			name:        "stops at dash dash",
+			args:        []string{"--", "-newbool"},
+			wantKnown:   []string{"--", "-newbool"},
+			wantUnknown: []string{},
+		},
+		{
+			name:    "undefined flag",
+			args:    []string{"-bogus", "."},
+			wantErr: true,
+		},
+		{
+			name:    "missing value",
+			args:    []string{"-newvalue"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			known, unknown, err := splitUnknownFlags(newTestGoFlagSet(), tt.args, toolchain)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("want error, got known %v, unknown %v", known, unknown)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(known, tt.wantKnown) {
+				t.Errorf("known = %q, want %q", known, tt.wantKnown)
+			}
+			if !reflect.DeepEqual(unknown, tt.wantUnknown) {
+				t.Errorf("unknown = %q, want %q", unknown, tt.wantUnknown)
+			}
+		})
+	}
+}
+
+func TestParseBuildFlags(t *testing.T) {
+	help := "usage: go build [-o output] [build flags] [packages]\n" +
+		"\n" +
+		"The build flags are shared by the build, clean, get, install, list, run,\n" +
+		"and test commands:\n" +
+		"\n" +
+		"\t-C dir\n" +
+		"\t\tChange to dir before running the command.\n" +
+		"\t-a\n" +
+		"\t\tforce rebuilding of packages that are already up-to-date.\n" +
+		"\t-asmflags '[pattern=]arg list'\n" +
+		"\t-buildvcs\n" +
+		"\t\t-buildvcs=true to error out if version control information is available but\n" +
+		"\t-covermode set,count,atomic\r\n"
+
+	want := map[string]bool{
+		"C":         true,
+		"a":         false,
+		"asmflags":  true,
+		"buildvcs":  false,
+		"covermode": true,
+	}
+	if got := parseBuildFlags(help); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestParseGococoFlags(t *testing.T) {
+	tests := []struct {
+		name        string
+		compileType int
+		args        []string
+		wantRest    []string
+		wantMode    string
+		wantPkgs    []string
+	}{
+		{
+			name:        "mixed with go flags",
+			compileType: GOCOCO_DO_BUILD,
+			args:        []string{"-race", "--gococo-mode=count", "-tags", "t", "-gococo-cover-pkg", "./a/...", "."},
+			wantRest:    []string{"-race", "-tags", "t", "."},
+			wantMode:    "count",
+			wantPkgs:    []string{"./a/..."},
+		},
+		{
+			name:        "the value of the go flag is kept",
+			compileType: GOCOCO_DO_BUILD,
+			args:        []string{"-o", "--gococo-mode=set", "."},
+			wantRest:    []string{"-o", "--gococo-mode=set", "."},
+			wantMode:    DEFAULT_COVER_MODE,
+		},
+		{
+			name:        "repeated and aliased",
+			compileType: GOCOCO_DO_BUILD,
+			args:        []string{"--gococo-cover-pkg=./a/...", "--cover-pkg", "./b/...", "."},
+			wantRest:    []string{"."},
+			wantMode:    DEFAULT_COVER_MODE,
+			wantPkgs:    []string{"./a/...", "./b/..."},
+		},
+		{
+			name:        "flags after the package of build",
+			compileType: GOCOCO_DO_BUILD,
+			args:        []string{".", "--gococo-mode=set"},
+			wantRest:    []string{"."},
+			wantMode:    "set",
+		},
+		{
+			name:        "program args of run",
+			compileType: GOCOCO_DO_RUN,
+			args:        []string{"--gococo-mode=set", "-tags", "t", ".", "--gococo-mode=count", "-race"},
+			wantRest:    []string{"-tags", "t", ".", "--gococo-mode=count", "-race"},
+			wantMode:    "set",
+		},
+		{
+			name:        "dash dash",
+			compileType: GOCOCO_DO_BUILD,
+			args:        []string{"--", "--gococo-mode=set"},
+			wantRest:    []string{"--", "--gococo-mode=set"},
+			wantMode:    DEFAULT_COVER_MODE,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Compile{compileType: tt.compileType}
+			rest := c.parseGococoFlags(tt.args, newTestGoFlagSet())
+
+			if !reflect.DeepEqual(rest, tt.wantRest) {
+				t.Errorf("rest = %q, want %q", rest, tt.wantRest)
+			}
+			if c.coverMode != tt.wantMode {
+				t.Errorf("mode = %v, want %v", c.coverMode, tt.wantMode)
+			}
+			if !reflect.DeepEqual(c.coverPkgFlags, tt.wantPkgs) {
+				t.Errorf("cover pkgs = %q, want %q", c.coverPkgFlags, tt.wantPkgs)
+			}
+		})
+	}
+}
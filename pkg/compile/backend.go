@@ -0,0 +1,314 @@
+package compile
+
+import (
+	_ "embed"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/lyyyuna/gococo/pkg/log"
+)
+
+const (
+	// BACKEND_AUTO selects the native backend if the go version and the mode support it, or the source backend
+	BACKEND_AUTO = "auto"
+	// BACKEND_SOURCE rewrites the go files in the cache with `go tool cover`
+	BACKEND_SOURCE = "source"
+	// BACKEND_NATIVE builds with `go build -cover`, the agent reads the counters by runtime/coverage
+	BACKEND_NATIVE = "native"
+
+	// AGENT_NATIVE_FILE is the generated file in the agent package for the native backend
+	AGENT_NATIVE_FILE = "native.go"
+	// AGENT_NATIVE_DECODE_FILE is the decoder of the coverage data copied from package native
+	AGENT_NATIVE_DECODE_FILE = "native_decode.go"
+
+	// nativeMinGoMinor is the minor version of go 1.20, which introduces runtime/coverage
+	nativeMinGoMinor = 20
+)
+
+// resolveBackend resolves the auto backend by the version of the go command.
+//
+// runtime/coverage only reads the counters in the atomic mode, the other modes
+// fall back to the source backend.
+func (c *Compile) resolveBackend() {
+	switch c.backend {
+	case BACKEND_AUTO:
+		c.backend = BACKEND_SOURCE
+		if c.coverMode == "atomic" && goMinorVersion() >= nativeMinGoMinor {
+			c.backend = BACKEND_NATIVE
+		}
+		log.Debugf("%v backend is selected", c.backend)
+	case BACKEND_NATIVE:
+		if c.coverMode != "atomic" {
+			log.Fatalf("the %v backend only supports the atomic mode", BACKEND_NATIVE)
+		}
+		if goMinorVersion() < nativeMinGoMinor {
+			log.Fatalf("the %v backend requires go1.%v or later", BACKEND_NATIVE, nativeMinGoMinor)
+		}
+	}
+}
+
+// goMinorVersion returns the minor version of the go command, e.g., 20 for go1.20.1,
+// the development version is considered as the latest.
+func goMinorVersion() int {
+	version := readGoEnv("", "GOVERSION")
+	if !strings.HasPrefix(version, "go1.") {
+		return int(^uint(0) >> 1)
+	}
+
+	n, ok := minorVersion(strings.TrimPrefix(version, "go"))
+	if !ok {
+		log.Fatalf("unknown go version: %v", version)
+	}
+
+	return n
+}
+
+// minorVersion returns the minor version of the go version like 1.20.1, 1.21rc2 or 1.14
+func minorVersion(version string) (int, bool) {
+	if !strings.HasPrefix(version, "1.") {
+		return 0, false
+	}
+
+	minor := strings.TrimPrefix(version, "1.")
+	if i := strings.IndexFunc(minor, func(r rune) bool { return r < '0' || r > '9' }); i >= 0 {
+		minor = minor[:i]
+	}
+	n, err := strconv.Atoi(minor)
+	if err != nil {
+		return 0, false
+	}
+
+	return n, true
+}
+
+// mergeCoverFlags merges the -cover flags in the command line into the native backend,
+// -coverpkg overrides the packages selected by gococo.
+func (c *Compile) mergeCoverFlags(cover bool, mode string, pkgs string) {
+	if mode != "" && mode != "atomic" {
+		log.Fatalf("the %v backend only supports -covermode=atomic", BACKEND_NATIVE)
+	}
+
+	c.userCover = cover || pkgs != ""
+	c.userCoverPkg = pkgs
+	if pkgs != "" {
+		log.Infof("-coverpkg is set, the packages selected by gococo are ignored")
+	}
+}
+
+// nativeCoverFlags returns the -cover flags of the native backend, the packages
+// selected to instrument are passed by -coverpkg.
+func (c *Compile) nativeCoverFlags() []string {
+	if c.backend != BACKEND_NATIVE {
+		return nil
+	}
+
+	pkgs := strings.Join(append(append([]string{}, c.nativeMainPkgs...), c.nativePkgs...), ",")
+	if c.userCoverPkg != "" {
+		pkgs = c.userCoverPkg
+	}
+	if pkgs == "" && !c.userCover {
+		return nil
+	}
+
+	flags := []string{"-cover", "-covermode=" + c.coverMode}
+	if pkgs != "" {
+		flags = append(flags, "-coverpkg="+pkgs)
+	}
+
+	return flags
+}
+
+// selectNativeMainPackages selects the main packages to build for -coverpkg besides the selected packages.
+//
+// the go files in the command line are covered if the package of their directory is selected.
+// the runtime has no coverage meta-data unless the main package is built with -cover,
+// so the main packages not selected are built with -cover too, but left out of the profile.
+func (c *Compile) selectNativeMainPackages() {
+	c.nativeMainPkgs, c.nativeSkipFiles = nil, nil
+
+	selected := make(map[string]struct{}, len(c.nativePkgs))
+	for _, importPath := range c.nativePkgs {
+		selected[importPath] = struct{}{}
+		if pkg, ok := c.pkgs[importPath]; ok {
+			selected[pkg.Dir] = struct{}{}
+		}
+	}
+
+	patterns := c.modifedArgs
+	if len(patterns) == 0 {
+		patterns = []string{"."}
+	}
+	for importPath, pkg := range c.listPackages(c.curWd, patterns...) {
+		if pkg.Name != "main" {
+			continue
+		}
+
+		if importPath == cmdlineFilesPackage {
+			c.nativeMainPkgs = append(c.nativeMainPkgs, importPath)
+			if _, ok := selected[pkg.Dir]; ok {
+				continue
+			}
+		} else {
+			if _, ok := selected[importPath]; ok {
+				continue
+			}
+			c.nativeMainPkgs = append(c.nativeMainPkgs, importPath)
+		}
+
+		for _, file := range append(append([]string{}, pkg.GoFiles...), pkg.CgoFiles...) {
+			if importPath == cmdlineFilesPackage {
+				c.nativeSkipFiles = append(c.nativeSkipFiles, filepath.Join(pkg.Dir, file))
+			} else {
+				c.nativeSkipFiles = append(c.nativeSkipFiles, path.Join(importPath, file))
+			}
+		}
+	}
+	sort.Strings(c.nativeMainPkgs)
+	sort.Strings(c.nativeSkipFiles)
+}
+
+// writeNativeAgent drops or removes the native part of the agent
+func (c *Compile) writeNativeAgent(agentDir string) {
+	file := filepath.Join(agentDir, AGENT_NATIVE_FILE)
+	decodeFile := filepath.Join(agentDir, AGENT_NATIVE_DECODE_FILE)
+	if c.backend != BACKEND_NATIVE {
+		os.Remove(file)
+		os.Remove(decodeFile)
+		return
+	}
+
+	src := "// Code generated by gococo. DO NOT EDIT.\n\n" + strings.Replace(nativeDecodeSrc, "package native\n", "package gococoagent\n", 1)
+	if err := os.WriteFile(decodeFile, []byte(src), 0644); err != nil {
+		log.Fatalf("fail to write the file %v: %v", decodeFile, err)
+	}
+
+	writeTemplate(file, nativeTmpl, map[string]interface{}{
+		"AgentMainFile": AGENT_MAIN_FILE,
+		"CachedWd":      c.cachedPath(c.curWd) + string(filepath.Separator),
+		"Wd":            c.curWd + string(filepath.Separator),
+		"SkipFiles":     c.nativeSkipFiles,
+	})
+}
+
+// nativeDecodeSrc is the source of package native, which decodes the coverage data for the agent
+//
+//go:embed native/decode.go
+var nativeDecodeSrc string
+
+// nativeTmpl reads the counters by runtime/coverage, and converts the meta-data and counter data,
+// decoded by the copy of package native, to the text profile.
+var nativeTmpl = template.Must(template.New("native").Parse(`// Code generated by gococo. DO NOT EDIT.
+
+package gococoagent
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+	"runtime/coverage"
+	"sort"
+	"strings"
+)
+
+func init() {
+	nativeProfile = writeNativeProfile
+	nativeReset = coverage.ClearCounters
+}
+
+// agentMainFile is generated by gococo, but instrumented by -cover as a file of the main package
+const agentMainFile = {{printf "%q" .AgentMainFile}}
+
+// skipFiles are the files of the main packages not selected, instrumented only for the meta-data
+var skipFiles = map[string]bool{
+{{- range .SkipFiles}}
+	{{printf "%q" .}}: true,
+{{- end}}
+}
+
+// the go files in the command line are named by the absolute paths, map them back from the cache
+const (
+	cachedWd = {{printf "%q" .CachedWd}}
+	wd       = {{printf "%q" .Wd}}
+)
+
+// writeNativeProfile writes the profile lines, without the mode line
+func writeNativeProfile(w io.Writer) error {
+	var meta, counters bytes.Buffer
+	if err := coverage.WriteMeta(&meta); err != nil {
+		return err
+	}
+	if err := coverage.WriteCounters(&counters); err != nil {
+		return err
+	}
+
+	pkgs, err := decodeMeta(meta.Bytes())
+	if err != nil {
+		return err
+	}
+	counts, err := decodeCounters(counters.Bytes())
+	if err != nil {
+		return err
+	}
+
+	// the same unit may be listed more than once, like what go tool covdata does
+	units := make(map[nativeUnit]uint32)
+	for pi, funcs := range pkgs {
+		for fi, fn := range funcs {
+			ctrs := counts[[2]uint32{uint32(pi), uint32(fi)}]
+			for k, u := range fn.units {
+				if strings.HasPrefix(u.file, cachedWd) {
+					u.file = wd + strings.TrimPrefix(u.file, cachedWd)
+				}
+				var n uint32
+				if k < len(ctrs) {
+					n = ctrs[k]
+				}
+				if sum := units[u] + n; sum >= n {
+					units[u] = sum
+				} else {
+					units[u] = ^uint32(0)
+				}
+			}
+		}
+	}
+
+	sorted := make([]nativeUnit, 0, len(units))
+	for u := range units {
+		if path.Base(u.file) == agentMainFile || skipFiles[u.file] {
+			continue
+		}
+		sorted = append(sorted, u)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.file != b.file {
+			return a.file < b.file
+		}
+		if a.stLine != b.stLine {
+			return a.stLine < b.stLine
+		}
+		if a.enLine != b.enLine {
+			return a.enLine < b.enLine
+		}
+		if a.stCol != b.stCol {
+			return a.stCol < b.stCol
+		}
+		if a.enCol != b.enCol {
+			return a.enCol < b.enCol
+		}
+		return a.nxStmts < b.nxStmts
+	})
+
+	for _, u := range sorted {
+		fmt.Fprintf(w, "%s:%d.%d,%d.%d %d %d\n", u.file, u.stLine, u.stCol, u.enLine, u.enCol, u.nxStmts, units[u])
+	}
+
+	return nil
+}
+`))
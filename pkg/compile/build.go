@@ -1,5 +1,148 @@
 package compile
 
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/lyyyuna/gococo/pkg/log"
+)
+
+// newBuild runs `go build` inside the cache directory, with the modified flags and args
 func (c *Compile) newBuild() {
+	c.goCompile("build")
+}
+
+// goCompile runs `go build` or `go install` inside the cache directory,
+// it returns false if the go command fails.
+func (c *Compile) goCompile(verb string) bool {
+	if c.overlay != nil {
+		c.writeOverlay()
+	}
+	args := c.goArgs(verb)
+
+	command := append([]string{"go"}, args...)
+	log.Infow(strings.Join(command, " "), log.Strings("command", command))
+	c.exitCode = c.runGo(args...)
+	if c.exitCode != 0 {
+		log.Errorw(fmt.Sprintf("go %v failed with exit code: %v", verb, c.exitCode), log.Strings("command", command), log.Int("exitCode", c.exitCode))
+		return false
+	}
+
+	log.Donew(fmt.Sprintf("go %v done", verb), log.Strings("command", command), log.Int("exitCode", c.exitCode))
+	return true
+}
+
+// goArgs returns the arguments of `go build` or `go install` running in the cache directory
+func (c *Compile) goArgs(verb string) []string {
+	args := []string{verb}
+	args = append(args, c.modifiedFlags...)
+	args = append(args, c.nativeCoverFlags()...)
+	if c.overlay != nil {
+		args = append(args, "-overlay="+c.overlayFile())
+	}
+	args = append(args, c.cachedArgs()...)
+
+	return args
+}
+
+// runGo runs the go command in the cache directory which corresponds to the current working directory.
+//
+// the output of the go command is streamed to the stdout/stderr directly,
+// and the exit code of the go command is returned.
+func (c *Compile) runGo(args ...string) int {
+	cmd := exec.Command("go", args...)
+	cmd.Dir = c.cachedPath(c.curWd)
+	cmd.Env = append(os.Environ(), c.goEnv()...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	log.Debugf("running go command in: %v", cmd.Dir)
+	if err := cmd.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return exitErr.ExitCode()
+		}
+		log.Fatalf("fail to execute the go command: %v", err)
+	}
+
+	return 0
+}
+
+// goEnv returns the extra environment variables for the go command running in the cache
+func (c *Compile) goEnv() []string {
+	env := c.target.env()
+
+	// the downloaded module of `install pkg@version` is built on its own
+	if c.moduleEnv != nil {
+		return append(env, c.moduleEnv...)
+	}
+
+	// the workspace is rewritten for the copied modules
+	if c.cachedGoWork != "" {
+		return append(env, "GOWORK="+c.cachedGoWork)
+	}
+
+	// the copied project is a standalone module, do not let
+	// the go command pick up a go.work from the parent directories.
+	return append(env, "GOWORK=off")
+}
+
+// cachedArgs maps the absolute paths in the pure arguments to the cache directory
+func (c *Compile) cachedArgs() []string {
+	args := make([]string, 0, len(c.modifedArgs))
+	for _, arg := range c.modifedArgs {
+		if filepath.IsAbs(arg) {
+			arg = c.cachedPath(arg)
+		}
+		args = append(args, arg)
+	}
+
+	// the go files in the command line make a package, the generated files join them
+	if len(args) != 0 && strings.HasSuffix(args[0], ".go") {
+		dir := filepath.Dir(args[0])
+		for _, file := range []string{AGENT_MAIN_FILE, AGENT_REGISTER_FILE} {
+			generated := filepath.Join(dir, file)
+			cachedFile := generated
+			if !filepath.IsAbs(cachedFile) {
+				cachedFile = filepath.Join(c.cachedPath(c.curWd), generated)
+			}
+			if fileExists(cachedFile) {
+				args = append(args, generated)
+			}
+		}
+	}
+
+	return args
+}
+
+// cmdlineFiles returns the directory and the names of the go files in the command line,
+// the directory is empty if the arguments are packages.
+func (c *Compile) cmdlineFiles() (string, map[string]struct{}) {
+	if len(c.modifedArgs) == 0 || !strings.HasSuffix(c.modifedArgs[0], ".go") {
+		return "", nil
+	}
+
+	files := make(map[string]struct{}, len(c.modifedArgs))
+	for _, arg := range c.modifedArgs {
+		files[filepath.Base(arg)] = struct{}{}
+	}
+
+	dir := filepath.Dir(c.modifedArgs[0])
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(c.curWd, dir)
+	}
+	return filepath.Clean(dir), files
+}
+
+// cachedPath returns the corresponding path in the cache of an original path.
+//
+// the path outside the project root directory and the local modules is returned as it is.
+func (c *Compile) cachedPath(p string) string {
+	if dst, ok := c.cache.lookupCachedPath(p); ok {
+		return dst
+	}
 
+	return p
 }
@@ -2,20 +2,32 @@ package compile
 
 import (
 	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
-	"reflect"
-	"strconv"
+	"runtime"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/lyyyuna/gococo/pkg/log"
 )
 
 const (
 	CACHE_ROOT_DIR = ".gococo"
-	CACHE_DIGEST   = "digest.modtime"
+	CACHE_DIGEST   = "digest.sha256"
+)
+
+const (
+	// CACHE_MODE_AUTO clones the files, or hard links the files which are never rewritten,
+	// falling back to copy
+	CACHE_MODE_AUTO = "auto"
+	// CACHE_MODE_COPY always copies the bytes
+	CACHE_MODE_COPY = "copy"
 )
 
 // cache can skip coping to temp if files not changed.
@@ -24,20 +36,41 @@ const (
 //
 //	.gococo					// cacheRootDir
 //	  ├─ project			// cacheDir
-//	  └─ digest.modtime		// digest file
+//	  └─ digest.sha256		// digest file
+//
+// the digest file records the content hash of every copied file,
+// only the added or changed files are copied, and the removed ones are deleted.
+//
+//	.gococo
+//	  ├─ instrumented			// the instrumented go files of every package, keyed by the source hash,
+//	  │   └─ <key>				// build tags, cover mode and gococo version
+//	  └─ instrumented.digest	// the key and the instrumented files of every package in the project copy
 type cache struct {
 	// the path for the digest file
 	digestFilePath string
 
 	// the digest info of last time
-	oldDigest map[string]int64
+	oldDigest map[string]string
 
 	// the digest info of this time
-	newDigest map[string]int64
+	newDigest map[string]string
 
-	// tell if the cache needs to refresh
+	// tell if the whole cache needs to refresh
 	needsRefresh bool
 
+	// the packages whose files are copied this time, keyed by import path,
+	// the files in other packages are the same as last time
+	dirtyPkgs map[string]struct{}
+
+	// the instrument key of every package in the project copy, of last time and this time
+	oldInstrumentDigest map[string]string
+	newInstrumentDigest map[string]string
+
+	// the instrumented source files of every package in the project copy, of last time and this time,
+	// they are not always the go files listed this time, e.g., for another GOOS
+	oldInstrumentFiles map[string][]string
+	newInstrumentFiles map[string][]string
+
 	// the base directory of target
 	targetDir string
 
@@ -50,8 +83,20 @@ type cache struct {
 	// to skip some files, like `.git` and self
 	skipPattern map[string]struct{}
 
-	// package information of the project
+	// package information of the project, and the local modules
 	pkgs []*Package
+
+	// the directories of the local modules used by the project, see readLocalModules
+	localDirs []string
+
+	// the vendor directory copied as a whole, empty if not built with vendor
+	vendorDir string
+
+	// how to populate the cache, set by --gococo-cache-mode or the GOCOCO_CACHE_MODE environment variable
+	mode string
+
+	// the go.mod files rewritten in the cache, they and the go files are never hard linked
+	rewritable map[string]struct{}
 }
 
 type cacheOption func(*cache)
@@ -71,27 +116,85 @@ func withPackage(pkgs map[string]*Package) cacheOption {
 	}
 }
 
+// withLocalModules copies the local modules too, the ones outside the project
+// are copied to the external directory.
+func withLocalModules(dirs []string, pkgs map[string]*Package) cacheOption {
+	return func(bc *cache) {
+		// the innermost directory is matched first
+		bc.localDirs = append(bc.localDirs, dirs...)
+		sort.Slice(bc.localDirs, func(i, j int) bool {
+			return len(bc.localDirs[i]) > len(bc.localDirs[j])
+		})
+
+		for _, pkg := range pkgs {
+			bc.pkgs = append(bc.pkgs, pkg)
+		}
+	}
+}
+
+// withCacheDir sets the cache directory relative to the target, GOCOCO_CACHE_DIR is used if empty
+func withCacheDir(dir string) cacheOption {
+	return func(bc *cache) {
+		if dir != "" {
+			bc.cacheRootDir = filepath.Join(bc.targetDir, dir)
+		}
+	}
+}
+
+// withCacheMode sets how to populate the cache, GOCOCO_CACHE_MODE is used if empty
+func withCacheMode(mode string) cacheOption {
+	return func(bc *cache) {
+		if mode != "" {
+			bc.mode = mode
+		}
+	}
+}
+
 func newCache(target string, opts ...cacheOption) *cache {
 	if target == "" {
 		log.Fatalf("empty target for the cache")
 	}
 
 	bc := &cache{
-		oldDigest:   make(map[string]int64),
-		newDigest:   make(map[string]int64),
-		targetDir:   target,
-		skipPattern: make(map[string]struct{}),
-		pkgs:        make([]*Package, 0),
+		oldDigest:           make(map[string]string),
+		newDigest:           make(map[string]string),
+		dirtyPkgs:           make(map[string]struct{}),
+		oldInstrumentDigest: make(map[string]string),
+		newInstrumentDigest: make(map[string]string),
+		oldInstrumentFiles:  make(map[string][]string),
+		newInstrumentFiles:  make(map[string][]string),
+		targetDir:           target,
+		skipPattern:         make(map[string]struct{}),
+		pkgs:                make([]*Package, 0),
+		rewritable:          make(map[string]struct{}),
 	}
 
 	for _, o := range opts {
 		o(bc)
 	}
 
-	if dir := os.Getenv("GOCOCO_CACHE_DIR"); dir != "" {
-		bc.cacheRootDir = filepath.Join(target, dir)
-	} else {
-		bc.cacheRootDir = filepath.Join(target, CACHE_ROOT_DIR)
+	if bc.cacheRootDir == "" {
+		if dir := os.Getenv("GOCOCO_CACHE_DIR"); dir != "" {
+			bc.cacheRootDir = filepath.Join(target, dir)
+		} else {
+			bc.cacheRootDir = filepath.Join(target, CACHE_ROOT_DIR)
+		}
+	}
+
+	if bc.mode == "" {
+		switch mode := os.Getenv("GOCOCO_CACHE_MODE"); mode {
+		case "", CACHE_MODE_AUTO:
+			bc.mode = CACHE_MODE_AUTO
+		case CACHE_MODE_COPY:
+			bc.mode = mode
+		default:
+			log.Fatalf("unknown cache mode: %v, should be %v or %v", mode, CACHE_MODE_AUTO, CACHE_MODE_COPY)
+		}
+	}
+
+	// the local replacements in go.mod are rewritten
+	for _, dir := range append([]string{target}, bc.localDirs...) {
+		bc.rewritable[filepath.Join(dir, "go.mod")] = struct{}{}
 	}
 
 	bc.cacheDir = filepath.Join(bc.cacheRootDir, "porject")
@@ -104,42 +207,120 @@ func newCache(target string, opts ...cacheOption) *cache {
 	if found := bc.loadOldDigest(); !found {
 		bc.needsRefresh = true
 	}
+	bc.loadInstrumentDigest()
+
+	// the cache directory may be removed by someone else
+	if _, err := os.Stat(bc.cacheDir); err != nil {
+		bc.needsRefresh = true
+	}
 
 	return bc
 }
 
-// Refreshed tells if the cache is refreshed
+// Refreshed tells if the whole cache is refreshed
 func (bc *cache) Refreshed() bool {
 	return bc.needsRefresh
 }
 
+// Dirty tells if the files of the package are copied this time
+func (bc *cache) Dirty(importPath string) bool {
+	_, ok := bc.dirtyPkgs[importPath]
+	return ok
+}
+
 func (bc *cache) doCopy() {
 	// get new digest from target
 	bc.getNewDigest()
 
-	// check if need to refresh cache
-	if !bc.needsRefresh {
-		eq := reflect.DeepEqual(bc.newDigest, bc.oldDigest)
-		if eq {
-			bc.needsRefresh = false
-			return
-		} else {
-			bc.needsRefresh = true
+	// the project copy no longer matches the digest until saveDigest,
+	// if the compile fails with a fatal error before, the whole cache is refreshed next time
+	if err := os.Remove(bc.digestFilePath); err != nil && !os.IsNotExist(err) {
+		log.Fatalf("fail to remove the old digest file: %v", err)
+	}
+
+	if bc.needsRefresh {
+		// remove old project copy, the instrumented output is kept
+		if err := os.RemoveAll(bc.cacheDir); err != nil {
+			log.Fatalf("fail to remove old cache: %v", err)
+		}
+
+		// create new cache dir
+		if err := os.MkdirAll(bc.cacheRootDir, os.ModePerm); err != nil {
+			log.Fatalf("fail to make cache: %v", err)
+		}
+
+		bc.oldDigest = make(map[string]string)
+		bc.oldInstrumentDigest = make(map[string]string)
+		bc.oldInstrumentFiles = make(map[string][]string)
+	}
+
+	changed, removed := bc.diff()
+
+	// a package is dirty if any of its files is changed or removed,
+	// all the files of a dirty package are copied, as they will be instrumented again.
+	changedSet := make(map[string]struct{}, len(changed))
+	for _, f := range changed {
+		changedSet[f] = struct{}{}
+	}
+	removedDirs := make(map[string]struct{})
+	for _, f := range removed {
+		removedDirs[filepath.Dir(f)] = struct{}{}
+	}
+
+	for _, pkg := range bc.pkgs {
+		files := bc.sourceFiles(pkg)
+
+		dirty := false
+		if _, ok := removedDirs[pkg.Dir]; ok {
+			dirty = true
+		}
+		for _, f := range files {
+			if _, ok := changedSet[f]; ok {
+				dirty = true
+				break
+			}
+		}
+		if !dirty {
+			continue
+		}
+
+		bc.dirtyPkgs[pkg.ImportPath] = struct{}{}
+		for _, f := range files {
+			if _, ok := changedSet[f]; !ok {
+				changedSet[f] = struct{}{}
+				changed = append(changed, f)
+			}
 		}
 	}
 
-	// remove old cache
-	if err := os.RemoveAll(bc.cacheRootDir); err != nil {
-		log.Fatalf("fail to remove old cache: %v", err)
+	log.Debugf("cache: %v files to copy, %v files to delete, %v dirty packages", len(changed), len(removed), len(bc.dirtyPkgs))
+
+	bc.doRemove(removed)
+	bc.pruneExternal()
+	bc.doRealCopy(changed)
+}
+
+// diff compares the new digest with the old one,
+// returns the added or changed files, and the removed files.
+func (bc *cache) diff() ([]string, []string) {
+	changed := make([]string, 0)
+	for path, hash := range bc.newDigest {
+		if old, ok := bc.oldDigest[path]; !ok || old != hash {
+			changed = append(changed, path)
+		}
 	}
 
-	// create new cache dir
-	if err := os.MkdirAll(bc.cacheRootDir, os.ModePerm); err != nil {
-		log.Fatalf("fail to make cache: %v", err)
+	removed := make([]string, 0)
+	for path := range bc.oldDigest {
+		if _, ok := bc.newDigest[path]; !ok {
+			removed = append(removed, path)
+		}
 	}
 
-	// copy all files
-	bc.doRealCopy()
+	sort.Strings(changed)
+	sort.Strings(removed)
+
+	return changed, removed
 }
 
 func (bc *cache) loadOldDigest() (found bool) {
@@ -161,15 +342,12 @@ func (bc *cache) loadOldDigest() (found bool) {
 			if len(trimed) == 0 {
 				continue
 			}
-			line := strings.Split(trimed, " ")
+			// the path may contain spaces, so the hash goes first
+			line := strings.SplitN(trimed, " ", 2)
 			if len(line) != 2 {
 				log.Fatalf("the line in digest file is in wrong format: %v", trimed)
 			}
-			modTime, err := strconv.ParseInt(line[1], 10, 64)
-			if err != nil {
-				log.Fatalf("fail to parse digest info of: %v", trimed)
-			}
-			bc.oldDigest[line[0]] = modTime
+			bc.oldDigest[line[1]] = line[0]
 		}
 	}
 
@@ -177,100 +355,277 @@ func (bc *cache) loadOldDigest() (found bool) {
 }
 
 func (bc *cache) getNewDigest() {
-	// find all source files
-	srcFiles := make([]string, 0)
-	for _, pkg := range bc.pkgs {
-		srcFiles = append(srcFiles, bc.sourceFiles(pkg)...)
-	}
+	srcFiles := bc.allFiles()
+	hashes := make([]string, len(srcFiles))
 
-	for _, src := range srcFiles {
-		info, err := os.Lstat(src)
+	err := forEachFile(srcFiles, "hashed", func(i int, src string) error {
+		hash, err := hashFile(src)
 		if err != nil {
-			log.Fatalf("fail to get %v's info: %v", src, err)
+			return fmt.Errorf("fail to get %v's digest: %w", src, err)
 		}
 
-		switch {
-		case info.Mode()&os.ModeSymlink != 0:
-			log.Debugf("found symlink: %v, follow the symlink to check mod time", src)
-			orig, err := os.Readlink(src)
-			if err != nil {
-				log.Fatalf("fail to read symlink: %v", err)
-			}
+		hashes[i] = hash
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
 
-			f, err := os.Stat(orig)
-			if err != nil {
-				log.Fatalf("fail to get %v's info: %v", src, err)
+	for i, src := range srcFiles {
+		bc.newDigest[src] = hashes[i]
+	}
+}
+
+// forEachFile calls fn for every file with a bounded worker pool,
+// the progress is shown in the spinner, the first error is returned.
+func forEachFile(files []string, verb string, fn func(i int, file string) error) error {
+	workers := runtime.NumCPU()
+	if workers > len(files) {
+		workers = len(files)
+	}
+
+	var (
+		wg       sync.WaitGroup
+		done     int64
+		once     sync.Once
+		firstErr error
+		failed   int32
+	)
+
+	indexes := make(chan int)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				if atomic.LoadInt32(&failed) != 0 {
+					continue
+				}
+
+				if err := fn(i, files[i]); err != nil {
+					once.Do(func() { firstErr = err })
+					atomic.StoreInt32(&failed, 1)
+					continue
+				}
+
+				n := atomic.AddInt64(&done, 1)
+				log.UpdateWait(fmt.Sprintf("%v %v/%v files", verb, n, len(files)))
 			}
+		}()
+	}
 
-			bc.newDigest[src] = f.ModTime().UnixNano()
+	for i := range files {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
 
-		default:
-			f, err := os.Stat(src)
-			if err != nil {
-				log.Fatalf("fail to get %v's info: %v", src, err)
-			}
+	return firstErr
+}
 
-			bc.newDigest[src] = f.ModTime().UnixNano()
-		}
+// hashFile returns the sha256 of the file content, the symlink is followed
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
 	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
-func (bc *cache) doRealCopy() {
+// allFiles returns all the files to copy: go.mod, go.sum, the source files and the vendor directory
+func (bc *cache) allFiles() []string {
 	srcFiles := make([]string, 0)
-	modFile := ""
-	for _, pkg := range bc.pkgs {
-		if modFile == "" {
-			modFile = pkg.Module.GoMod
-			srcFiles = append(srcFiles, modFile)
-			sumFile := filepath.Join(bc.targetDir, "go.sum")
-			if _, err := os.Lstat(sumFile); err == nil {
-				srcFiles = append(srcFiles, sumFile)
+	for _, dir := range append([]string{bc.targetDir}, bc.localDirs...) {
+		for _, name := range []string{"go.mod", "go.sum"} {
+			if f := filepath.Join(dir, name); fileExists(f) {
+				srcFiles = append(srcFiles, f)
 			}
 		}
-		srcFiles = append(srcFiles, bc.sourceFiles(pkg)...)
 	}
 
-	for _, src := range srcFiles {
-		relPath, err := filepath.Rel(bc.targetDir, src)
-		if err != nil {
-			log.Fatalf("the file: %v is not in the project directory, gococo currently cannot deal with such file", src)
+	// the files of the vendored packages to instrument are listed twice
+	seen := make(map[string]struct{})
+	for _, pkg := range bc.pkgs {
+		for _, f := range bc.sourceFiles(pkg) {
+			seen[f] = struct{}{}
+			srcFiles = append(srcFiles, f)
 		}
+	}
 
-		dst := filepath.Join(bc.cacheDir, relPath)
-		dstDir := filepath.Dir(dst)
-		err = os.MkdirAll(dstDir, os.ModePerm)
-		if err != nil {
-			log.Fatalf("fail to create the directory in the cache : %v, %v", dstDir, err)
+	for _, f := range bc.vendorFiles() {
+		if _, ok := seen[f]; !ok {
+			srcFiles = append(srcFiles, f)
 		}
+	}
 
-		f, err := os.Create(dst)
-		if err != nil {
-			log.Fatalf("fail to create the file in the cache directory: %v", err)
+	return srcFiles
+}
+
+// cachedPath returns the path in the cache of the original file
+func (bc *cache) cachedPath(src string) string {
+	dst, ok := bc.lookupCachedPath(src)
+	if !ok {
+		log.Fatalf("the file: %v is not in the project directory or the local modules", src)
+	}
+
+	return dst
+}
+
+// lookupCachedPath returns the path in the cache of the original file,
+// the files in the local modules outside the project are copied to the external directory.
+func (bc *cache) lookupCachedPath(src string) (string, bool) {
+	if isSubPath(bc.targetDir, src) {
+		relPath, _ := filepath.Rel(bc.targetDir, src)
+		return filepath.Join(bc.cacheDir, relPath), true
+	}
+
+	return bc.externalPath(src)
+}
+
+// doRemove deletes the removed files from the cache,
+// the directory left with only gococo generated files is deleted too.
+func (bc *cache) doRemove(removed []string) {
+	dirs := make(map[string]struct{})
+	for _, src := range removed {
+		// the module is no longer a local module, its copy is pruned as a whole
+		dst, ok := bc.lookupCachedPath(src)
+		if !ok {
+			continue
 		}
-		defer f.Close()
+		if err := os.Remove(dst); err != nil && !os.IsNotExist(err) {
+			log.Fatalf("fail to remove the file in the cache: %v", err)
+		}
+		dirs[filepath.Dir(dst)] = struct{}{}
+	}
 
-		s, err := os.Open(src)
+	for dir := range dirs {
+		entries, err := os.ReadDir(dir)
 		if err != nil {
-			log.Fatalf("fail to open the original file: %v", err)
+			continue
 		}
-		defer s.Close()
 
-		if _, err = io.Copy(f, s); err != nil {
-			log.Fatalf("fail to copy the file: %v", err)
+		generatedOnly := true
+		for _, e := range entries {
+			if e.IsDir() || !strings.HasPrefix(e.Name(), "zz_gococo_") {
+				generatedOnly = false
+				break
+			}
+		}
+		if generatedOnly {
+			if err := os.RemoveAll(dir); err != nil {
+				log.Fatalf("fail to remove the directory in the cache: %v", err)
+			}
 		}
 	}
 }
 
+func (bc *cache) doRealCopy(srcFiles []string) {
+	var cloned, linked, copied int64
+
+	err := forEachFile(srcFiles, "copied", func(_ int, src string) error {
+		dst := bc.cachedPath(src)
+
+		if bc.mode == CACHE_MODE_AUTO {
+			if err := prepareDst(dst); err != nil {
+				return err
+			}
+
+			// the cloned file is copied on write, the original is never touched
+			if err := reflink(src, dst); err == nil {
+				atomic.AddInt64(&cloned, 1)
+				return nil
+			}
+
+			// the hard linked file shares the data with the original, only for the files never rewritten,
+			// any go file may be instrumented or have the main function renamed, e.g., for another GOOS
+			if _, ok := bc.rewritable[src]; !ok && filepath.Ext(src) != ".go" {
+				if err := os.Link(src, dst); err == nil {
+					atomic.AddInt64(&linked, 1)
+					return nil
+				}
+			}
+		}
+
+		atomic.AddInt64(&copied, 1)
+		return copyFile(src, dst)
+	})
+	if err != nil {
+		log.Fatalf("fail to copy the file: %v", err)
+	}
+
+	log.Debugf("cache: %v files cloned, %v files linked, %v files copied", cloned, linked, copied)
+}
+
+// prepareDst creates the directory of dst, and removes the old dst,
+// so the file linked to the old dst is never written.
+func prepareDst(dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return fmt.Errorf("fail to create the directory in the cache: %v, %w", filepath.Dir(dst), err)
+	}
+
+	if err := os.Remove(dst); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("fail to remove the old file in the cache: %w", err)
+	}
+
+	return nil
+}
+
+// copyFile copies the file to dst, the old dst is removed first
+func copyFile(src string, dst string) error {
+	if err := prepareDst(dst); err != nil {
+		return err
+	}
+
+	s, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("fail to open the original file: %w", err)
+	}
+	defer s.Close()
+
+	f, err := os.Create(dst)
+	if err != nil {
+		return fmt.Errorf("fail to create the file in the cache directory: %w", err)
+	}
+
+	if _, err = io.Copy(f, s); err != nil {
+		f.Close()
+		return fmt.Errorf("fail to copy %v: %w", src, err)
+	}
+
+	// the file is closed as soon as it is copied, not to keep too many files open
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("fail to write %v: %w", dst, err)
+	}
+
+	return nil
+}
+
 // saveDigest saves the digest info to the disk
 func (bc *cache) saveDigest() {
+	bc.saveInstrumentDigest()
+	bc.pruneInstrumented()
+
 	f, err := os.Create(bc.digestFilePath)
 	if err != nil {
 		log.Fatalf("fail to create the new digest file: %v", err)
 	}
 	defer f.Close()
 
-	for path, modTime := range bc.newDigest {
-		line := fmt.Sprintf("%v %v\n", path, modTime)
+	paths := make([]string, 0, len(bc.newDigest))
+	for path := range bc.newDigest {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		line := fmt.Sprintf("%v %v\n", bc.newDigest[path], path)
 		f.WriteString(line)
 	}
 }
@@ -343,5 +698,10 @@ func (bc *cache) sourceFiles(pkg *Package) []string {
 		out = append(out, help(f))
 	}
 
+	// the default profile of -pgo=auto
+	if pgo := help("default.pgo"); pkg.Name == "main" && fileExists(pgo) {
+		out = append(out, pgo)
+	}
+
 	return out
 }
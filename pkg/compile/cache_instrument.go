@@ -0,0 +1,246 @@
+package compile
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lyyyuna/gococo/pkg/log"
+	"github.com/lyyyuna/gococo/pkg/version"
+)
+
+const (
+	// CACHE_INSTRUMENT_DIR stores the instrumented go files of every package, keyed by instrumentKey
+	CACHE_INSTRUMENT_DIR = "instrumented"
+
+	// CACHE_INSTRUMENT_DIGEST records the instrumentKey of every package in the project copy
+	CACHE_INSTRUMENT_DIGEST = "instrumented.digest"
+
+	// the instrumented output not used for this long is pruned
+	instrumentCacheTTL = 7 * 24 * time.Hour
+)
+
+// instrumentKey identifies the instrumented output of a package, it changes if any of
+// the go files, the files to instrument, the build tags, the cover mode, the coverage variable names
+// or the gococo version changes.
+func (bc *cache) instrumentKey(pkg *Package, files []string, buildTags string, mode string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "version: %v\n", version.Version)
+	fmt.Fprintf(h, "vars: %v\n", coverVarPrefix)
+	fmt.Fprintf(h, "mode: %v\n", mode)
+	fmt.Fprintf(h, "tags: %v\n", buildTags)
+	fmt.Fprintf(h, "package: %v\n", pkg.ImportPath)
+	for _, f := range pkg.GoFiles {
+		src := filepath.Join(pkg.Dir, f)
+		hash, ok := bc.newDigest[src]
+		if !ok {
+			var err error
+			if hash, err = hashFile(src); err != nil {
+				log.Fatalf("fail to get %v's digest: %v", src, err)
+			}
+		}
+		fmt.Fprintf(h, "file: %v %v\n", f, hash)
+	}
+	for _, f := range files {
+		fmt.Fprintf(h, "instrument: %v\n", f)
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// instrumentedInPlace tells if the package in the project copy is already instrumented with the key
+func (bc *cache) instrumentedInPlace(importPath string, key string) bool {
+	if bc.Dirty(importPath) {
+		return false
+	}
+
+	return bc.oldInstrumentDigest[importPath] == key
+}
+
+// loadInstrumented copies the instrumented go files of the key to the directory,
+// returns false if not found.
+func (bc *cache) loadInstrumented(key string, files []string, dstDir string) bool {
+	storeDir := filepath.Join(bc.cacheRootDir, CACHE_INSTRUMENT_DIR, key)
+	for _, f := range files {
+		if _, err := os.Stat(filepath.Join(storeDir, f)); err != nil {
+			return false
+		}
+	}
+
+	for _, f := range files {
+		if err := copyFile(filepath.Join(storeDir, f), filepath.Join(dstDir, f)); err != nil {
+			log.Fatalf("fail to load the instrumented file: %v", err)
+		}
+	}
+
+	// mark as recently used
+	now := time.Now()
+	os.Chtimes(storeDir, now, now)
+
+	return true
+}
+
+// saveInstrumented stores the instrumented go files in the directory with the key
+func (bc *cache) saveInstrumented(key string, files []string, srcDir string) {
+	storeDir := filepath.Join(bc.cacheRootDir, CACHE_INSTRUMENT_DIR, key)
+	tmpDir := storeDir + ".tmp"
+	os.RemoveAll(tmpDir)
+
+	for _, f := range files {
+		if err := copyFile(filepath.Join(srcDir, f), filepath.Join(tmpDir, f)); err != nil {
+			log.Fatalf("fail to save the instrumented file: %v", err)
+		}
+	}
+
+	// the store directory is either complete or absent
+	os.RemoveAll(storeDir)
+	if err := os.Rename(tmpDir, storeDir); err != nil {
+		log.Fatalf("fail to save the instrumented files: %v", err)
+	}
+}
+
+// setInstrumented records the key and the instrumented source files of the package in the project copy
+func (bc *cache) setInstrumented(importPath string, key string, files []string) {
+	bc.newInstrumentDigest[importPath] = key
+	bc.newInstrumentFiles[importPath] = files
+}
+
+// lastInstrumented returns the source files of the package instrumented last time,
+// the go files of the package if the digest is written by an old version without the files.
+func (bc *cache) lastInstrumented(pkg *Package) []string {
+	if _, ok := bc.oldInstrumentDigest[pkg.ImportPath]; !ok {
+		return nil
+	}
+	if files, ok := bc.oldInstrumentFiles[pkg.ImportPath]; ok {
+		return files
+	}
+
+	files := make([]string, 0, len(pkg.GoFiles))
+	for _, f := range pkg.GoFiles {
+		files = append(files, filepath.Join(pkg.Dir, f))
+	}
+	return files
+}
+
+// restoreFile copies back the original file to the project copy, unless it is removed
+func (bc *cache) restoreFile(src string) {
+	if _, err := os.Stat(src); os.IsNotExist(err) {
+		return
+	}
+	if err := copyFile(src, bc.cachedPath(src)); err != nil {
+		log.Fatalf("fail to restore the file: %v", err)
+	}
+}
+
+// restoreUninstrumented copies back the original files of the packages
+// which are instrumented last time, but not this time.
+func (bc *cache) restoreUninstrumented() {
+	pkgs := make(map[string]*Package, len(bc.pkgs))
+	for _, pkg := range bc.pkgs {
+		pkgs[pkg.ImportPath] = pkg
+	}
+
+	for importPath := range bc.oldInstrumentDigest {
+		if _, ok := bc.newInstrumentDigest[importPath]; ok {
+			continue
+		}
+
+		files, recorded := bc.oldInstrumentFiles[importPath]
+		pkg, listed := pkgs[importPath]
+		switch {
+		case recorded:
+		case listed:
+			files = bc.lastInstrumented(pkg)
+		default:
+			// the vendored package not selected any more is not listed
+			bc.restoreVendored(importPath)
+			continue
+		}
+
+		for _, src := range files {
+			bc.restoreFile(src)
+		}
+		if len(files) != 0 {
+			os.Remove(filepath.Join(bc.cachedPath(filepath.Dir(files[0])), AGENT_REGISTER_FILE))
+		}
+
+		log.Debugf("package %v is not instrumented any more, original files restored", importPath)
+	}
+}
+
+// loadInstrumentDigest reads the instrument digest, every package is a line of the key and the import path,
+// followed by the instrumented source files, one per line indented by a tab.
+func (bc *cache) loadInstrumentDigest() {
+	f, err := os.Open(filepath.Join(bc.cacheRootDir, CACHE_INSTRUMENT_DIGEST))
+	if os.IsNotExist(err) {
+		return
+	} else if err != nil {
+		log.Fatalf("fail to load the instrument digest: %v", err)
+	}
+	defer f.Close()
+
+	importPath := ""
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if file := strings.TrimPrefix(s.Text(), "\t"); file != s.Text() {
+			if importPath != "" {
+				bc.oldInstrumentFiles[importPath] = append(bc.oldInstrumentFiles[importPath], file)
+			}
+			continue
+		}
+
+		importPath = ""
+		line := strings.SplitN(strings.TrimSpace(s.Text()), " ", 2)
+		if len(line) != 2 {
+			continue
+		}
+		importPath = line[1]
+		bc.oldInstrumentDigest[importPath] = line[0]
+	}
+}
+
+func (bc *cache) saveInstrumentDigest() {
+	f, err := os.Create(filepath.Join(bc.cacheRootDir, CACHE_INSTRUMENT_DIGEST))
+	if err != nil {
+		log.Fatalf("fail to create the instrument digest: %v", err)
+	}
+	defer f.Close()
+
+	importPaths := make([]string, 0, len(bc.newInstrumentDigest))
+	for importPath := range bc.newInstrumentDigest {
+		importPaths = append(importPaths, importPath)
+	}
+	sort.Strings(importPaths)
+
+	for _, importPath := range importPaths {
+		fmt.Fprintf(f, "%v %v\n", bc.newInstrumentDigest[importPath], importPath)
+		for _, file := range bc.newInstrumentFiles[importPath] {
+			fmt.Fprintf(f, "\t%v\n", file)
+		}
+	}
+}
+
+// pruneInstrumented removes the instrumented output not used for a long time
+func (bc *cache) pruneInstrumented() {
+	storeRoot := filepath.Join(bc.cacheRootDir, CACHE_INSTRUMENT_DIR)
+	entries, err := os.ReadDir(storeRoot)
+	if err != nil {
+		return
+	}
+
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		if time.Since(info.ModTime()) > instrumentCacheTTL {
+			os.RemoveAll(filepath.Join(storeRoot, e.Name()))
+		}
+	}
+}
@@ -39,6 +39,66 @@ type Compile struct {
 	// buildTags, extract go build tags from the original args, as they will change the compile behavior
 	buildTags string
 
+	// coverPkgFlags and coverExcludeFlags are the package patterns of --cover-pkg and --cover-exclude
+	coverPkgFlags     []string
+	coverExcludeFlags []string
+
+	// workModuleFlags are the workspace modules of --gococo-work-modules, or GOCOCO_WORK_MODULES
+	workModuleFlags []string
+
+	// vendorPkgFlags are the vendored package patterns of --gococo-vendor-packages, or GOCOCO_VENDOR_PACKAGES
+	vendorPkgFlags []string
+
+	// coverPkgs and coverExcludes are compiled from the flags above, see selected
+	coverPkgs     []*pkgPattern
+	coverExcludes []*pkgPattern
+
+	// coverGenerated, instrument the generated go files too, set by --cover-generated
+	coverGenerated bool
+
+	// coverMode is the cover mode, set by --gococo-mode
+	coverMode string
+
+	// agentAddr and serverAddr are the default addresses built into the agent,
+	// set by --gococo-agent-addr and --gococo-server
+	agentAddr  string
+	serverAddr string
+
+	// cacheDir and cacheMode, set by --gococo-cache-dir and --gococo-cache-mode
+	cacheDir  string
+	cacheMode string
+
+	// target is the platform to build for
+	target target
+	// listFlags are the go flags passed to go list, which change the selected go files
+	listFlags []string
+
+	// outputFlag is the -o flag of build as it is, see resolveOutput
+	outputFlag string
+
+	// backend is how to instrument the packages, set by --gococo-backend, see resolveBackend
+	backend string
+	// nativePkgs is the import paths passed to -coverpkg in the native backend
+	nativePkgs []string
+	// nativeMainPkgs are the main packages to build passed to -coverpkg besides nativePkgs,
+	// see selectNativeMainPackages
+	nativeMainPkgs []string
+	// nativeSkipFiles are the files of the main packages not selected, left out of the profile
+	nativeSkipFiles []string
+	// userCover tells if -cover or -coverpkg is set in the command line
+	userCover bool
+	// userCoverPkg is the -coverpkg in the command line, it replaces nativePkgs
+	userCoverPkg string
+	// logFormat is set by --gococo-log-format
+	logFormat string
+	// explain is the format to print the plan in, set by --gococo-explain, see explainPlan
+	explain boolOrString
+	// showFlagsHelp, set by --gococo-help
+	showFlagsHelp bool
+
+	// overlay is the -overlay replacements with absolute paths, see readOverlay
+	overlay map[string]string
+
 	// buildMod, extract go build mod type from the original args
 	buildMod string
 
@@ -57,8 +117,57 @@ type Compile struct {
 	// projectModulePath represents the [module-path] of the project
 	projectModulePath string
 
+	// projectModuleDir is the directory of the project module, where the agent package is put,
+	// it is the project root directory unless the project root is a go.work directory but not a module
+	projectModuleDir string
+
+	// isWorkRoot tells if the project root is a go.work directory but not a module,
+	// the project module is the first go.work member to instrument
+	isWorkRoot bool
+
 	// pkgs
 	pkgs map[string]*Package
+
+	// localModules are the directories of the local modules used by the project,
+	// the local replacements and the go.work members, except the project itself
+	localModules []string
+
+	// workDirs are the directories of the go.work members and their module paths, except the project itself,
+	// their packages are listed in pkgs
+	workDirs map[string]string
+
+	// workModules are the module paths to instrument, the project and the go.work members by default
+	workModules map[string]struct{}
+
+	// vendorPkgs are the vendored packages selected to instrument, they are listed in pkgs too
+	vendorPkgs map[string]*Package
+
+	// localPkgs are the packages of the local modules, they are copied but not instrumented
+	localPkgs map[string]*Package
+
+	// cachedGoWork is the go.work rewritten in the cache, for the workspace mode only
+	cachedGoWork string
+
+	// pkgCovers holds the coverage variables of the instrumented packages, keyed by import path
+	pkgCovers map[string]*PackageCover
+
+	// cache is the copied project in the temporary directory
+	cache *cache
+
+	// programArgs are the arguments passed to the program, for run only
+	programArgs []string
+
+	// runBinary is the path of the built binary in the temporary directory, for run only
+	runBinary string
+
+	// moduleDir is the temporary copy of the downloaded module, for `install pkg@version` only
+	moduleDir string
+
+	// moduleEnv is the environment of the go commands on the downloaded module, for `install pkg@version` only
+	moduleEnv []string
+
+	// exitCode is the exit code of the underlying go command, or the program for run
+	exitCode int
 }
 
 // Option represents a compile option
@@ -102,6 +211,12 @@ func NewCompile(opts ...Option) *Compile {
 		o(c)
 	}
 
+	log.SetPhase(log.PHASE_PARSE)
+
+	// the temporary directories are removed however gococo exits
+	log.SetExitCallback(c.cleanup)
+	defer c.cleanup()
+
 	// we should get wd first!!!
 	wd, err := os.Getwd()
 	if err != nil {
@@ -112,21 +227,68 @@ func NewCompile(opts ...Option) *Compile {
 	// parse the flags and args
 	c.parseArgs()
 
+	// install pkg@version, the module is the project
+	if c.compileType == GOCOCO_DO_INSTALL {
+		c.prepareModuleInstall()
+	}
+
 	// get project meta info
 	c.readProjectMetaInfo()
+	c.resolveOutput()
+
+	// only print what would be done
+	if c.explain != "" {
+		c.explainPlan()
+		return c
+	}
+
+	c.compile()
+
+	// the program runs after the project is unlocked
+	if c.compileType == GOCOCO_DO_RUN && c.exitCode == 0 {
+		log.SetPhase(log.PHASE_RUN)
+		c.newRun()
+	}
 
-	// lock coping + injecting
+	return c
+}
+
+// compile copies, injects and builds the project with the project locked
+func (c *Compile) compile() {
+	// lock coping + injecting + building
 	compileLock := newCompileMutex(filepath.Join(c.curProjectRootDir, ".gococo.lock"), time.Second*360)
 	if err := compileLock.Lock(); err != nil {
 		log.Fatalf("fail to lock the project: %v", err)
 	}
 	defer compileLock.Unlock()
 
-	cache := newCache(c.curProjectRootDir,
-		withPackage(c.pkgs),
-	)
-	cache.doCopy()
-	cache.saveDigest()
+	log.SetPhase(log.PHASE_COPY)
+	c.copyProject()
+	log.SetPhase(log.PHASE_INSTRUMENT)
+	c.instrumentProject()
+	c.injectAgent()
+	c.cache.saveDigest()
 
-	return c
+	log.SetPhase(log.PHASE_BUILD)
+	switch c.compileType {
+	case GOCOCO_DO_BUILD, GOCOCO_DO_RUN:
+		c.newBuild()
+	case GOCOCO_DO_INSTALL:
+		c.newInstall()
+	}
+}
+
+// cleanup removes the temporary directories of run and install
+func (c *Compile) cleanup() {
+	if c.runBinary != "" {
+		os.RemoveAll(filepath.Dir(c.runBinary))
+	}
+	if c.moduleDir != "" {
+		os.RemoveAll(c.moduleDir)
+	}
+}
+
+// ExitCode returns the exit code of the underlying go command
+func (c *Compile) ExitCode() int {
+	return c.exitCode
 }
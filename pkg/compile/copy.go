@@ -6,9 +6,21 @@ import "github.com/lyyyuna/gococo/pkg/log"
 func (c *Compile) copyProject() {
 	log.StartWait("coping project to the temporary directory")
 
-	buildCache := newCache(c.curProjectRootDir,
+	c.cache = c.newProjectCache()
+	c.cache.doCopy()
+	c.rewriteLocalModules()
+
+	log.StopWait()
+	log.Donef("project copied to the temporary directory")
+}
+
+// newProjectCache creates the cache of the project, nothing is copied yet
+func (c *Compile) newProjectCache() *cache {
+	return newCache(c.curProjectRootDir,
 		withPackage(c.pkgs),
+		withLocalModules(c.localModules, c.localPkgs),
+		withVendor(c.isBuildModVendor),
+		withCacheDir(c.cacheDir),
+		withCacheMode(c.cacheMode),
 	)
-
-	buildCache.doCopy()
 }
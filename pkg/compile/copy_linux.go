@@ -0,0 +1,30 @@
+package compile
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// reflink clones the file with FICLONE, the data blocks are shared with
+// the original file and copied on write, it fails if the filesystem does not support it.
+func reflink(src string, dst string) error {
+	s, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	if err := unix.IoctlFileClone(int(f.Fd()), int(s.Fd())); err != nil {
+		f.Close()
+		os.Remove(dst)
+		return err
+	}
+
+	return f.Close()
+}
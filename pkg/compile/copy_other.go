@@ -0,0 +1,10 @@
+//go:build !linux
+
+package compile
+
+import "errors"
+
+// reflink is only supported on linux
+func reflink(src string, dst string) error {
+	return errors.New("reflink is not supported")
+}
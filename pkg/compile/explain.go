@@ -0,0 +1,135 @@
+package compile
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/lyyyuna/gococo/pkg/log"
+)
+
+const (
+	// EXPLAIN_TEXT prints the plan in the human readable form
+	EXPLAIN_TEXT = "text"
+	// EXPLAIN_JSON prints the plan in json
+	EXPLAIN_JSON = "json"
+)
+
+// plan is what gococo decides to do for the compile
+type plan struct {
+	ProjectRoot  string   `json:"projectRoot"`
+	ModulePath   string   `json:"modulePath"`
+	GoWork       string   `json:"goWork"`
+	Vendor       bool     `json:"vendor"`
+	LocalModules []string `json:"localModules"`
+	Target       string   `json:"target"`
+	Backend      string   `json:"backend"`
+	CoverMode    string   `json:"coverMode"`
+	CacheDir     string   `json:"cacheDir"`
+	Packages     []string `json:"packages"`
+	Flags        []string `json:"flags"`
+	Dir          string   `json:"dir"`
+	Env          []string `json:"env"`
+	Command      []string `json:"command"`
+	ProgramArgs  []string `json:"programArgs,omitempty"`
+}
+
+// explainPlan prints the resolved project information, the packages to instrument and
+// the go command to run in the cache, without copying or building anything.
+func (c *Compile) explainPlan() {
+	c.cache = c.newProjectCache()
+	if c.goWorkEnabled() {
+		c.cachedGoWork = c.cachedGoWorkPath()
+	}
+
+	pkgs := c.selectPackages()
+	if c.backend == BACKEND_NATIVE {
+		c.nativePkgs = pkgs
+		c.selectNativeMainPackages()
+	}
+
+	verb := "build"
+	if c.compileType == GOCOCO_DO_INSTALL {
+		verb = "install"
+	}
+
+	p := plan{
+		ProjectRoot:  c.curProjectRootDir,
+		ModulePath:   c.projectModulePath,
+		GoWork:       c.curGoWork,
+		Vendor:       c.isBuildModVendor,
+		LocalModules: c.localModules,
+		Target:       c.target.GOOS + "/" + c.target.GOARCH,
+		Backend:      c.backend,
+		CoverMode:    c.coverMode,
+		CacheDir:     c.cache.cacheDir,
+		Packages:     pkgs,
+		Flags:        c.modifiedFlags,
+		Dir:          c.cachedPath(c.curWd),
+		Env:          c.goEnv(),
+		Command:      append([]string{"go"}, c.goArgs(verb)...),
+		ProgramArgs:  c.programArgs,
+	}
+
+	if c.explain == EXPLAIN_JSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(p); err != nil {
+			log.Fatalf("fail to print the plan: %v", err)
+		}
+		return
+	}
+
+	p.print()
+}
+
+// print prints the plan in the human readable form
+func (p *plan) print() {
+	line := func(name string, value interface{}) {
+		fmt.Printf("%-16s %v\n", name+":", value)
+	}
+	list := func(name string, values []string) {
+		fmt.Printf("%v (%v):\n", name, len(values))
+		for _, v := range values {
+			fmt.Printf("  %v\n", v)
+		}
+	}
+
+	line("project root", p.ProjectRoot)
+	line("module path", p.ModulePath)
+	if p.GoWork == "" {
+		line("go.work", "none")
+	} else {
+		line("go.work", p.GoWork)
+	}
+	line("vendor", p.Vendor)
+	if len(p.LocalModules) != 0 {
+		list("local modules", p.LocalModules)
+	}
+	line("target", p.Target)
+	line("backend", p.Backend)
+	line("cover mode", p.CoverMode)
+	line("cache", p.CacheDir)
+	list("packages to instrument", p.Packages)
+	line("go flags", shellJoin(p.Flags))
+	line("directory", p.Dir)
+	line("command", shellJoin(append(append([]string{}, p.Env...), p.Command...)))
+	if len(p.ProgramArgs) != 0 {
+		line("program args", shellJoin(p.ProgramArgs))
+	}
+}
+
+// shellJoin joins the arguments, the ones with spaces or quotes are quoted
+func shellJoin(args []string) string {
+	quoted := make([]string, 0, len(args))
+	for _, arg := range args {
+		if arg == "" || strings.ContainsAny(arg, " \t\n\"'\\$") {
+			arg = strconv.Quote(arg)
+		}
+		quoted = append(quoted, arg)
+	}
+
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,417 @@
+package compile
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/lyyyuna/gococo/pkg/log"
+)
+
+const (
+	// CACHE_EXTERNAL_DIR holds the copies of the local modules outside the project root directory
+	CACHE_EXTERNAL_DIR = "external"
+
+	// CACHE_GOWORK is the go.work rewritten for the copied modules
+	CACHE_GOWORK = "go.work"
+)
+
+// modVersion is a module path with an optional version
+type modVersion struct {
+	Path    string
+	Version string
+}
+
+func (m modVersion) String() string {
+	if m.Version == "" {
+		return m.Path
+	}
+
+	return m.Path + "@" + m.Version
+}
+
+// modReplace is a replace directive output by `go mod edit -json` or `go work edit -json`
+type modReplace struct {
+	Old modVersion
+	New modVersion
+}
+
+// goModJSON is the subset of `go mod edit -json` output
+type goModJSON struct {
+	Module  modVersion
+	Go      string
+	Replace []modReplace
+}
+
+// goWorkJSON is the subset of `go work edit -json` output
+type goWorkJSON struct {
+	Go  string
+	Use []struct {
+		DiskPath string
+	}
+	Replace []modReplace
+}
+
+// goWorkEnabled tells if the project is built in the workspace mode
+func (c *Compile) goWorkEnabled() bool {
+	return c.curGoWork != "" && c.curGoWork != "off"
+}
+
+// readLocalModules finds the modules in the local directories used by the project,
+// they are the local replacements in go.mod or go.work, and the go.work members.
+func (c *Compile) readLocalModules() {
+	dirs := make(map[string]struct{})
+
+	modDirs := []string{c.curProjectRootDir}
+	if c.goWorkEnabled() {
+		work := readGoWorkJSON(c.curGoWork)
+		base := filepath.Dir(c.curGoWork)
+		for _, u := range work.Use {
+			modDirs = append(modDirs, absPath(base, u.DiskPath))
+		}
+		for _, r := range work.Replace {
+			if dir := localReplaceDir(r, base); dir != "" {
+				dirs[dir] = struct{}{}
+			}
+		}
+	}
+
+	for _, dir := range modDirs {
+		dirs[dir] = struct{}{}
+
+		// the replaced modules are vendored, and the replacements must match vendor/modules.txt
+		if c.isBuildModVendor || (c.isWorkRoot && dir == c.curProjectRootDir) {
+			continue
+		}
+		for _, r := range readGoModJSON(dir).Replace {
+			if local := localReplaceDir(r, dir); local != "" {
+				dirs[local] = struct{}{}
+			}
+		}
+	}
+	delete(dirs, c.curProjectRootDir)
+
+	c.localModules = make([]string, 0, len(dirs))
+	for dir := range dirs {
+		c.localModules = append(c.localModules, dir)
+	}
+	sort.Strings(c.localModules)
+
+	c.readWorkModules(modDirs[1:])
+
+	c.localPkgs = make(map[string]*Package)
+	for _, dir := range c.localModules {
+		if _, ok := c.workDirs[dir]; ok {
+			continue
+		}
+
+		modPath := readGoModJSON(dir).Module.Path
+		for importPath, pkg := range c.listPackages(c.curWd, modPath+"/...") {
+			if pkg.Module == nil || filepath.Clean(pkg.Module.Dir) != dir {
+				continue
+			}
+			c.localPkgs[importPath] = pkg
+		}
+
+		log.Debugf("local module %v found in: %v", modPath, dir)
+	}
+}
+
+// readWorkModules lists the packages of every go.work member, they are instrumented like the project,
+// unless --gococo-work-modules or GOCOCO_WORK_MODULES selects the modules to instrument by their module paths.
+//
+// the project root is a go.work directory but not a module, the first module to instrument is the project module.
+func (c *Compile) readWorkModules(dirs []string) {
+	c.workDirs = make(map[string]string)
+	c.workModules = make(map[string]struct{})
+
+	modPaths := make([]string, 0, len(dirs)+1)
+	if !c.isWorkRoot {
+		modPaths = append(modPaths, c.projectModulePath)
+	}
+	for _, dir := range dirs {
+		if dir == c.curProjectRootDir {
+			continue
+		}
+
+		for importPath, pkg := range c.listPackages(dir) {
+			if _, ok := c.pkgs[importPath]; ok {
+				log.Fatalf("the package %v is found in more than one workspace module", importPath)
+			}
+			c.pkgs[importPath] = pkg
+		}
+
+		modPath := readGoModJSON(dir).Module.Path
+		modPaths = append(modPaths, modPath)
+		c.workDirs[dir] = modPath
+		log.Debugf("workspace module %v found in: %v", modPath, dir)
+	}
+
+	selected := splitList(c.workModuleFlags, "GOCOCO_WORK_MODULES")
+	if len(selected) == 0 {
+		for _, modPath := range modPaths {
+			c.workModules[modPath] = struct{}{}
+		}
+		c.selectProjectModule(modPaths)
+		return
+	}
+
+	known := make(map[string]struct{}, len(modPaths))
+	for _, modPath := range modPaths {
+		known[modPath] = struct{}{}
+	}
+	for _, modPath := range selected {
+		if _, ok := known[modPath]; !ok {
+			log.Fatalf("the module %v selected to instrument is not in the workspace", modPath)
+		}
+		c.workModules[modPath] = struct{}{}
+	}
+	c.selectProjectModule(selected)
+}
+
+// selectProjectModule picks the first of the module paths as the project module,
+// if the project root is a go.work directory but not a module.
+func (c *Compile) selectProjectModule(modPaths []string) {
+	if !c.isWorkRoot {
+		return
+	}
+	if len(modPaths) == 0 {
+		log.Fatalf("no module is found in the go.work: %v", c.curGoWork)
+	}
+
+	for dir, modPath := range c.workDirs {
+		if modPath == modPaths[0] {
+			c.projectModulePath = modPath
+			c.projectModuleDir = dir
+			return
+		}
+	}
+}
+
+// rewriteLocalModules points the local replacements and the go.work members
+// to the copies in the cache, the go command resolves the same modules as the original project.
+func (c *Compile) rewriteLocalModules() {
+	for _, dir := range append([]string{c.curProjectRootDir}, c.localModules...) {
+		if c.isWorkRoot && dir == c.curProjectRootDir {
+			continue
+		}
+		c.rewriteGoMod(dir)
+	}
+
+	if c.goWorkEnabled() {
+		c.writeGoWork()
+	}
+}
+
+// rewriteGoMod rewrites the local replacements in the copied go.mod of the module
+func (c *Compile) rewriteGoMod(dir string) {
+	cachedDir := c.cachedPath(dir)
+
+	// the replaced modules are vendored, the go.mod must match vendor/modules.txt,
+	// the one rewritten by the last build without vendor is restored.
+	if c.isBuildModVendor {
+		if err := copyFile(filepath.Join(dir, "go.mod"), filepath.Join(cachedDir, "go.mod")); err != nil {
+			log.Fatalf("fail to restore the go.mod: %v", err)
+		}
+		return
+	}
+
+	editArgs := []string{"mod", "edit"}
+	for _, r := range readGoModJSON(dir).Replace {
+		if local := localReplaceDir(r, dir); local != "" {
+			editArgs = append(editArgs, fmt.Sprintf("-replace=%v=%v", r.Old, c.relCachedPath(cachedDir, local)))
+		}
+	}
+	if len(editArgs) == 2 {
+		return
+	}
+
+	runGoEdit(cachedDir, editArgs...)
+	log.Debugf("local replacements rewritten in: %v", filepath.Join(cachedDir, "go.mod"))
+}
+
+// cachedGoWorkPath returns the go.work rewritten for the cache
+func (c *Compile) cachedGoWorkPath() string {
+	return filepath.Join(c.cache.cacheRootDir, CACHE_GOWORK)
+}
+
+// writeGoWork writes the go.work in the cache, with the members and replacements in the cache
+func (c *Compile) writeGoWork() {
+	work := readGoWorkJSON(c.curGoWork)
+	base := filepath.Dir(c.curGoWork)
+	root := c.cache.cacheRootDir
+
+	dst := c.cachedGoWorkPath()
+	if err := copyFile(c.curGoWork, dst); err != nil {
+		log.Fatalf("fail to copy the go.work: %v", err)
+	}
+
+	// the checksums of the workspace are kept
+	if sum := c.curGoWork + ".sum"; fileExists(sum) {
+		if err := copyFile(sum, dst+".sum"); err != nil {
+			log.Fatalf("fail to copy the go.work.sum: %v", err)
+		}
+	}
+
+	editArgs := []string{"work", "edit"}
+	for _, u := range work.Use {
+		editArgs = append(editArgs, "-dropuse="+u.DiskPath, "-use="+c.relCachedPath(root, absPath(base, u.DiskPath)))
+	}
+	for _, r := range work.Replace {
+		if local := localReplaceDir(r, base); local != "" {
+			editArgs = append(editArgs, fmt.Sprintf("-replace=%v=%v", r.Old, c.relCachedPath(root, local)))
+		}
+	}
+	editArgs = append(editArgs, dst)
+
+	runGoEdit(root, editArgs...)
+	c.cachedGoWork = dst
+	log.Debugf("go.work rewritten in: %v", dst)
+}
+
+// relCachedPath returns the path of the copied directory relative to the base directory in the cache,
+// in the form of `./dir` or `../dir` as the go command requires.
+func (c *Compile) relCachedPath(base string, dir string) string {
+	cached := c.cachedPath(dir)
+	if cached == dir {
+		// not copied, use the original one
+		return dir
+	}
+
+	rel, err := filepath.Rel(base, cached)
+	if err != nil {
+		return cached
+	}
+	rel = filepath.ToSlash(rel)
+	if rel != ".." && !strings.HasPrefix(rel, "../") {
+		rel = "./" + rel
+	}
+
+	return rel
+}
+
+// externalPath returns the path in the cache of a file in the local modules outside the project
+func (bc *cache) externalPath(src string) (string, bool) {
+	for _, dir := range bc.localDirs {
+		if isSubPath(bc.targetDir, dir) || !isSubPath(dir, src) {
+			continue
+		}
+
+		rel, _ := filepath.Rel(dir, src)
+		return filepath.Join(bc.externalDir(dir), rel), true
+	}
+
+	return "", false
+}
+
+// externalDir returns the directory of the copied local module,
+// it is named after the directory, with a short hash to avoid conflicts.
+func (bc *cache) externalDir(dir string) string {
+	sum := sha256.Sum256([]byte(dir))
+
+	return filepath.Join(bc.cacheRootDir, CACHE_EXTERNAL_DIR, fmt.Sprintf("%v-%x", filepath.Base(dir), sum[:4]))
+}
+
+// pruneExternal removes the copies of the modules which are no longer local modules
+func (bc *cache) pruneExternal() {
+	root := filepath.Join(bc.cacheRootDir, CACHE_EXTERNAL_DIR)
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return
+	}
+
+	used := make(map[string]struct{})
+	for _, dir := range bc.localDirs {
+		used[filepath.Base(bc.externalDir(dir))] = struct{}{}
+	}
+
+	for _, e := range entries {
+		if _, ok := used[e.Name()]; ok {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(root, e.Name())); err != nil {
+			log.Fatalf("fail to remove the old local module copy: %v", err)
+		}
+	}
+}
+
+// localReplaceDir returns the directory of the local replacement, empty if it is replaced by a module.
+// the relative directory is based on the directory of go.mod or go.work.
+func localReplaceDir(r modReplace, base string) string {
+	// a module replacement always has a version
+	if r.New.Version != "" {
+		return ""
+	}
+
+	return absPath(base, r.New.Path)
+}
+
+func absPath(base string, p string) string {
+	if filepath.IsAbs(p) {
+		return filepath.Clean(p)
+	}
+
+	return filepath.Join(base, p)
+}
+
+// isSubPath tells if the path is the directory itself or inside it
+func isSubPath(dir string, p string) bool {
+	rel, err := filepath.Rel(dir, p)
+	if err != nil {
+		return false
+	}
+
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func fileExists(p string) bool {
+	_, err := os.Lstat(p)
+	return err == nil
+}
+
+func readGoModJSON(dir string) *goModJSON {
+	var mod goModJSON
+	readGoEditJSON(dir, &mod, "mod", "edit", "-json")
+
+	return &mod
+}
+
+func readGoWorkJSON(file string) *goWorkJSON {
+	var work goWorkJSON
+	readGoEditJSON(filepath.Dir(file), &work, "work", "edit", "-json", file)
+
+	return &work
+}
+
+func readGoEditJSON(dir string, v interface{}, args ...string) {
+	cmd := exec.Command("go", args...)
+	cmd.Dir = dir
+
+	var errBuf bytes.Buffer
+	cmd.Stderr = &errBuf
+	out, err := cmd.Output()
+	if err != nil {
+		log.Fatalf("fail to run go %v in %v: %v, stderr: %v", strings.Join(args, " "), dir, err, errBuf.String())
+	}
+
+	if err := json.Unmarshal(out, v); err != nil {
+		log.Fatalf("fail to parse the output of go %v: %v", strings.Join(args, " "), err)
+	}
+}
+
+func runGoEdit(dir string, args ...string) {
+	cmd := exec.Command("go", args...)
+	cmd.Dir = dir
+
+	var errBuf bytes.Buffer
+	cmd.Stderr = &errBuf
+	if err := cmd.Run(); err != nil {
+		log.Fatalf("fail to run go %v in %v: %v, stderr: %v", strings.Join(args, " "), dir, err, errBuf.String())
+	}
+}
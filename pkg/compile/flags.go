@@ -0,0 +1,229 @@
+package compile
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/lyyyuna/gococo/pkg/log"
+)
+
+// GOCOCO_FLAG_PREFIX is the prefix of the gococo flags, not to conflict with the go flags
+const GOCOCO_FLAG_PREFIX = "gococo-"
+
+// stringList is a flag which can be repeated
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+// gococoFlagSet defines the flags of gococo itself, shared by build, install and run
+func (c *Compile) gococoFlagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("gococo", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+
+	fs.StringVar(&c.coverMode, "gococo-mode", DEFAULT_COVER_MODE, "the cover mode: set, count or atomic")
+	fs.StringVar(&c.agentAddr, "gococo-agent-addr", "", "the listen address of the agent, default 127.0.0.1:0, GOCOCO_AGENT_ADDR overrides it at runtime")
+	fs.StringVar(&c.serverAddr, "gococo-server", "", "the gococo server to register with, GOCOCO_SERVER overrides it at runtime")
+	fs.StringVar(&c.cacheDir, "gococo-cache-dir", "", "the cache directory relative to the project root, default "+CACHE_ROOT_DIR+", or GOCOCO_CACHE_DIR")
+	fs.StringVar(&c.cacheMode, "gococo-cache-mode", "", "how to populate the cache: "+CACHE_MODE_AUTO+" or "+CACHE_MODE_COPY+", or GOCOCO_CACHE_MODE")
+	fs.StringVar(&c.backend, "gococo-backend", BACKEND_AUTO, "how to instrument: "+BACKEND_AUTO+" picks "+BACKEND_NATIVE+" if the go version and the mode support it, or falls back to "+BACKEND_SOURCE+", "+BACKEND_SOURCE+" rewrites the files, "+BACKEND_NATIVE+" uses go build -cover (go1.20+, atomic mode only)")
+	fs.StringVar(&c.logFormat, "gococo-log-format", "", "the log format: "+log.FORMAT_TEXT+" or "+log.FORMAT_JSON+", or GOCOCO_LOG_FORMAT")
+	fs.Var(&c.explain, "gococo-explain", "print the plan without copying or building, --gococo-explain=json prints json")
+	fs.BoolVar(&c.showFlagsHelp, "gococo-help", false, "show the gococo flags")
+
+	coverPkgs := (*stringList)(&c.coverPkgFlags)
+	fs.Var(coverPkgs, "gococo-cover-pkg", "the package patterns to instrument, repeatable or separated by comma")
+	fs.Var(coverPkgs, "cover-pkg", "deprecated, use --gococo-cover-pkg")
+	coverExcludes := (*stringList)(&c.coverExcludeFlags)
+	fs.Var(coverExcludes, "gococo-cover-exclude", "the package patterns not to instrument, repeatable or separated by comma")
+	fs.Var(coverExcludes, "cover-exclude", "deprecated, use --gococo-cover-exclude")
+	fs.BoolVar(&c.coverGenerated, "gococo-cover-generated", false, "instrument the generated go files too")
+	fs.BoolVar(&c.coverGenerated, "cover-generated", false, "deprecated, use --gococo-cover-generated")
+	fs.Var((*stringList)(&c.workModuleFlags), "gococo-work-modules", "the workspace modules to instrument, repeatable or separated by comma, default all, or GOCOCO_WORK_MODULES")
+	fs.Var((*stringList)(&c.vendorPkgFlags), "gococo-vendor-packages", "the vendored package patterns to instrument, repeatable or separated by comma, default none, or GOCOCO_VENDOR_PACKAGES")
+
+	return fs
+}
+
+// splitList splits the flag values separated by comma, the empty ones are dropped,
+// the environment variable is used if no value is set.
+func splitList(values []string, env string) []string {
+	if len(values) == 0 {
+		values = []string{os.Getenv(env)}
+	}
+
+	out := make([]string, 0, len(values))
+	for _, v := range values {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				out = append(out, s)
+			}
+		}
+	}
+
+	return out
+}
+
+// FlagsUsage returns the help text of the gococo flags
+func FlagsUsage() string {
+	var buf bytes.Buffer
+	fs := (&Compile{}).gococoFlagSet()
+	fs.SetOutput(&buf)
+	fs.PrintDefaults()
+
+	return buf.String()
+}
+
+// deprecatedFlags are the unprefixed aliases of the gococo flags
+var deprecatedFlags = map[string]string{
+	"cover-pkg":       "gococo-cover-pkg",
+	"cover-exclude":   "gococo-cover-exclude",
+	"cover-generated": "gococo-cover-generated",
+}
+
+// parseGococoFlags extracts the flags of gococo itself, in the form of `--name value` or `--name=value`,
+// the single dash is accepted too. the rest of the arguments are returned for the go flags.
+//
+// the values of the go flags are skipped, for run, the arguments after the package
+// are passed to the program as they are.
+func (c *Compile) parseGococoFlags(args []string, goFlags *flag.FlagSet) []string {
+	fs := c.gococoFlagSet()
+
+	rest := make([]string, 0, len(args))
+	gococoArgs := make([]string, 0)
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			rest = append(rest, args[i:]...)
+			break
+		}
+
+		name, value, hasValue := splitFlag(arg)
+		if name == "" && c.compileType == GOCOCO_DO_RUN {
+			rest = append(rest, args[i:]...)
+			break
+		}
+
+		f := fs.Lookup(name)
+		if f == nil {
+			if strings.HasPrefix(name, GOCOCO_FLAG_PREFIX) {
+				log.Fatalf("unknown gococo flag: %v, see --gococo-help", arg)
+			}
+			rest = append(rest, arg)
+			if !hasValue && goFlagTakesValue(goFlags, name) && i+1 < len(args) {
+				i++
+				rest = append(rest, args[i])
+			}
+			continue
+		}
+		if newName, ok := deprecatedFlags[name]; ok {
+			log.Warnf("--%v is deprecated, use --%v", name, newName)
+		}
+
+		if isBoolFlag(f) {
+			gococoArgs = append(gococoArgs, arg)
+			continue
+		}
+		if !hasValue {
+			if i+1 >= len(args) {
+				log.Fatalf("flag needs an argument: --%v", name)
+			}
+			i++
+			value = args[i]
+		}
+		gococoArgs = append(gococoArgs, "--"+name+"="+value)
+	}
+
+	if err := fs.Parse(gococoArgs); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	if c.logFormat != "" {
+		if err := log.SetFormat(c.logFormat); err != nil {
+			log.Fatalf("invalid --gococo-log-format: %v", err)
+		}
+	}
+
+	if c.showFlagsHelp {
+		fmt.Fprintf(os.Stderr, "gococo flags:\n%v", FlagsUsage())
+		os.Exit(0)
+	}
+
+	c.validateGococoFlags()
+
+	// leave stdout for the json plan
+	if c.explain == EXPLAIN_JSON {
+		log.ToStderr()
+	}
+
+	return rest
+}
+
+func (c *Compile) validateGococoFlags() {
+	switch c.coverMode {
+	case "set", "count", "atomic":
+	default:
+		log.Fatalf("invalid --gococo-mode: %v, should be set, count or atomic", c.coverMode)
+	}
+
+	switch c.backend {
+	case BACKEND_AUTO, BACKEND_SOURCE, BACKEND_NATIVE:
+	default:
+		log.Fatalf("invalid --gococo-backend: %v, should be %v, %v or %v", c.backend, BACKEND_AUTO, BACKEND_SOURCE, BACKEND_NATIVE)
+	}
+
+	switch c.explain {
+	case "", EXPLAIN_TEXT, EXPLAIN_JSON:
+	case "true":
+		c.explain = EXPLAIN_TEXT
+	case "false":
+		c.explain = ""
+	default:
+		log.Fatalf("invalid --gococo-explain: %v, should be %v or %v", c.explain, EXPLAIN_TEXT, EXPLAIN_JSON)
+	}
+
+	switch c.cacheMode {
+	case "", CACHE_MODE_AUTO, CACHE_MODE_COPY:
+	default:
+		log.Fatalf("invalid --gococo-cache-mode: %v, should be %v or %v", c.cacheMode, CACHE_MODE_AUTO, CACHE_MODE_COPY)
+	}
+
+	if c.agentAddr != "" {
+		if _, _, err := net.SplitHostPort(c.agentAddr); err != nil {
+			log.Fatalf("invalid --gococo-agent-addr: %v", err)
+		}
+	}
+
+	if c.serverAddr == "" {
+		return
+	}
+	server := strings.TrimPrefix(strings.TrimPrefix(c.serverAddr, "http://"), "https://")
+	if _, _, err := net.SplitHostPort(strings.TrimSuffix(server, "/")); err != nil {
+		log.Fatalf("invalid --gococo-server: %v", err)
+	}
+}
+
+// splitFlag splits the flag argument into the name and the value,
+// the name is empty if it is not a flag.
+func splitFlag(arg string) (name string, value string, hasValue bool) {
+	if len(arg) < 2 || arg[0] != '-' {
+		return "", "", false
+	}
+
+	name = strings.TrimPrefix(arg[1:], "-")
+	if i := strings.Index(name, "="); i >= 0 {
+		return name[:i], name[i+1:], true
+	}
+
+	return name, "", false
+}
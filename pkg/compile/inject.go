@@ -0,0 +1,190 @@
+package compile
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/lyyyuna/gococo/pkg/log"
+)
+
+// DEFAULT_COVER_MODE is the default cover mode used to instrument the source files,
+// the same as `go tool cover -mode=atomic`, it can be changed by --gococo-mode
+const DEFAULT_COVER_MODE = "atomic"
+
+// instrumentProject injects the coverage counters into all the main module packages in the cache,
+// in the workspace mode, they are the packages of the selected workspace modules.
+//
+// the instrumented output of every package is cached, a package is instrumented again
+// only if its go files, the build tags, the cover mode or the gococo version changes.
+func (c *Compile) instrumentProject() {
+	log.StartWait("instrumenting the project")
+
+	c.pkgCovers = make(map[string]*PackageCover)
+	c.nativePkgs = nil
+
+	for _, importPath := range c.selectPackages() {
+		// the native backend leaves the instrumentation to `go build -cover`
+		if c.backend == BACKEND_NATIVE {
+			c.nativePkgs = append(c.nativePkgs, importPath)
+			continue
+		}
+
+		if pkgCover := c.instrumentPackage(c.pkgs[importPath]); pkgCover != nil {
+			c.pkgCovers[importPath] = pkgCover
+		}
+	}
+	c.cache.restoreUninstrumented()
+
+	log.StopWait()
+	if c.backend == BACKEND_NATIVE {
+		c.selectNativeMainPackages()
+		log.Donef("%v packages selected for go build -cover", len(c.nativePkgs))
+		return
+	}
+	log.Donef("%v packages instrumented", len(c.pkgCovers))
+}
+
+// selectPackages returns the sorted import paths of the packages to instrument
+func (c *Compile) selectPackages() []string {
+	c.coverPkgs = c.compilePkgPatterns(c.coverPkgFlags)
+	c.coverExcludes = c.compilePkgPatterns(c.coverExcludeFlags)
+
+	importPaths := make([]string, 0, len(c.pkgs))
+	for importPath, pkg := range c.pkgs {
+		if !c.needsInstrument(pkg) {
+			log.Debugf("skip instrumenting package: %v", importPath)
+			continue
+		}
+		importPaths = append(importPaths, importPath)
+	}
+	sort.Strings(importPaths)
+
+	return importPaths
+}
+
+// needsInstrument tells if the package should be instrumented,
+// the dependency and standard packages are left untouched.
+func (c *Compile) needsInstrument(pkg *Package) bool {
+	if pkg.Standard || pkg.Goroot || pkg.DepOnly {
+		return false
+	}
+
+	// the vendored package selected by --gococo-vendor-packages
+	if _, ok := c.vendorPkgs[pkg.ImportPath]; ok {
+		return len(pkg.GoFiles) != 0
+	}
+
+	if pkg.Module == nil || !pkg.Module.Main {
+		return false
+	}
+
+	// the workspace module not selected
+	if _, ok := c.workModules[pkg.Module.Path]; !ok {
+		return false
+	}
+
+	if !c.selected(pkg.ImportPath) {
+		return false
+	}
+
+	return len(pkg.GoFiles) != 0
+}
+
+// instrumentPackage rewrites the go files of the package in the cache with per-block counters,
+// it returns nil if all the go files are skipped.
+func (c *Compile) instrumentPackage(pkg *Package) *PackageCover {
+	files := c.instrumentFiles(pkg)
+	if len(files) == 0 {
+		log.Debugf("all the go files of package %v are skipped", pkg.ImportPath)
+		return nil
+	}
+
+	pkgCover := &PackageCover{
+		Package: pkg,
+		Vars:    make(map[string]*FileVar),
+	}
+
+	selected := make(map[string]struct{}, len(files))
+	for _, file := range files {
+		selected[file] = struct{}{}
+	}
+
+	// the variable is named after the index in all the go files, not to change with the selection
+	for i, file := range pkg.GoFiles {
+		if _, ok := selected[file]; !ok {
+			continue
+		}
+		pkgCover.Vars[file] = &FileVar{
+			File: path.Join(pkg.ImportPath, file),
+			Var:  coverVarName(pkg.ImportPath, i),
+		}
+	}
+
+	srcFiles := make([]string, 0, len(files))
+	for _, file := range files {
+		srcFiles = append(srcFiles, filepath.Join(pkg.Dir, file))
+	}
+
+	key := c.cache.instrumentKey(pkg, files, c.buildTags, c.coverMode)
+	cachedDir := c.cachedPath(pkg.Dir)
+	if c.cache.instrumentedInPlace(pkg.ImportPath, key) {
+		log.Debugf("package %v not changed, reuse the instrumented files", pkg.ImportPath)
+		c.cache.setInstrumented(pkg.ImportPath, key, srcFiles)
+		return pkgCover
+	}
+
+	// the files instrumented last time may be skipped, or not built for this target
+	for _, src := range c.cache.lastInstrumented(pkg) {
+		if _, ok := selected[filepath.Base(src)]; ok && filepath.Dir(src) == pkg.Dir {
+			continue
+		}
+		c.cache.restoreFile(src)
+	}
+
+	if c.cache.loadInstrumented(key, files, cachedDir) {
+		log.Debugf("instrument cache hit: %v", pkg.ImportPath)
+	} else {
+		log.Debugf("instrument cache miss: %v", pkg.ImportPath)
+		for _, file := range files {
+			instrumentFile(filepath.Join(pkg.Dir, file), filepath.Join(cachedDir, file), pkgCover.Vars[file].Var, c.coverMode)
+		}
+		c.cache.saveInstrumented(key, files, cachedDir)
+	}
+	c.cache.setInstrumented(pkg.ImportPath, key, srcFiles)
+
+	return pkgCover
+}
+
+// coverVarPrefix is the prefix of the coverage variables generated by the source backend
+const coverVarPrefix = "GoCoco_"
+
+// coverVarName generates the coverage variable name for the n-th file of a package
+func coverVarName(importPath string, n int) string {
+	sum := sha256.Sum256([]byte(importPath))
+
+	return fmt.Sprintf("%v%d_%x", coverVarPrefix, n, sum[:6])
+}
+
+// instrumentFile uses `go tool cover` to instrument the original file, and writes the output to dst
+func instrumentFile(src string, dst string, coverVar string, mode string) {
+	tmpFile := dst + ".gococo.tmp"
+
+	cmd := exec.Command("go", "tool", "cover", "-mode", mode, "-var", coverVar, "-o", tmpFile, filepath.Base(src))
+	cmd.Dir = filepath.Dir(src)
+
+	var errBuf bytes.Buffer
+	cmd.Stderr = &errBuf
+	if err := cmd.Run(); err != nil {
+		log.Fatalf("fail to instrument file: %v, err: %v, stderr: %v", src, err, errBuf.String())
+	}
+
+	if err := os.Rename(tmpFile, dst); err != nil {
+		log.Fatalf("fail to replace the instrumented file: %v", err)
+	}
+}
@@ -0,0 +1,229 @@
+package compile
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/lyyyuna/gococo/pkg/log"
+)
+
+// newInstall runs `go install` inside the cache directory, so the binaries are installed to
+// where `go install` would put them: GOBIN, GOPATH/bin, or the Target reported by go list
+func (c *Compile) newInstall() {
+	if !c.goCompile("install") {
+		return
+	}
+
+	patterns := c.cachedArgs()
+	if len(patterns) == 0 {
+		patterns = []string{"."}
+	}
+
+	pkgs := c.listPackagesWithEnv(c.cachedPath(c.curWd), c.goEnv(), patterns...)
+	targets := make([]string, 0)
+	for _, pkg := range pkgs {
+		if pkg.Name == "main" && pkg.Target != "" {
+			targets = append(targets, pkg.Target)
+		}
+	}
+	sort.Strings(targets)
+
+	for _, target := range targets {
+		log.Donef("installed: %v", target)
+	}
+}
+
+// prepareModuleInstall handles `gococo install pkg@version` like `go install pkg@version`.
+//
+// the module is downloaded and copied to a temporary directory, which is treated as the project.
+// as what the go command does, the module with the replace or exclude directives is rejected.
+func (c *Compile) prepareModuleInstall() {
+	version := ""
+	pkgs := make([]string, 0)
+	for _, arg := range c.modifedArgs {
+		i := strings.Index(arg, "@")
+		if i < 0 {
+			continue
+		}
+		if i == 0 {
+			log.Fatalf("%v: invalid package@version argument", arg)
+		}
+		if version != "" && arg[i+1:] != version {
+			log.Fatalf("%v: all arguments must refer to packages in the same module at the same version (@%v)", arg, version)
+		}
+
+		version = arg[i+1:]
+		pkgs = append(pkgs, arg[:i])
+	}
+
+	if version == "" {
+		return
+	}
+	if len(pkgs) != len(c.modifedArgs) {
+		log.Fatalf("all arguments must refer to packages in the same module at the same version (@%v)", version)
+	}
+
+	mod := downloadModule(pkgs[0], version)
+	log.Donef("module downloaded: %v@%v", mod.Path, mod.Version)
+	checkModuleDirectives(mod)
+
+	tmpDir, err := os.MkdirTemp("", "gococo-install-")
+	if err != nil {
+		log.Fatalf("fail to create the temporary directory: %v", err)
+	}
+	c.moduleDir = tmpDir
+	copyDir(mod.Dir, tmpDir)
+
+	// the module without go.mod is given the one synthesized by the go command
+	if goMod := filepath.Join(tmpDir, "go.mod"); !fileExists(goMod) && mod.GoMod != "" {
+		if err := copyFile(mod.GoMod, goMod); err != nil {
+			log.Fatalf("fail to copy the go.mod: %v", err)
+		}
+	}
+
+	// the project is not the current module any more
+	c.moduleEnv = []string{
+		"GOWORK=off",
+		"GOFLAGS=" + strings.TrimSpace(os.Getenv("GOFLAGS")+" -mod=mod"),
+	}
+
+	args := make([]string, 0, len(pkgs))
+	for _, pkg := range pkgs {
+		if pkg != mod.Path && !strings.HasPrefix(pkg, mod.Path+"/") {
+			log.Fatalf("package %v is not in module %v", pkg, mod.Path)
+		}
+		args = append(args, "."+strings.TrimPrefix(pkg, mod.Path))
+	}
+
+	c.curWd = tmpDir
+	c.modifedArgs = args
+}
+
+// downloadModule finds the module which provides the package at the version,
+// the longest module path wins, like what the go command does.
+func downloadModule(pkg string, version string) *ModulePublic {
+	var lastErr string
+	for modPath := pkg; modPath != "." && modPath != "/"; modPath = path.Dir(modPath) {
+		cmd := exec.Command("go", "mod", "download", "-json", modPath+"@"+version)
+		cmd.Dir = os.TempDir()
+
+		var errBuf bytes.Buffer
+		cmd.Stderr = &errBuf
+		out, _ := cmd.Output()
+
+		var mod struct {
+			ModulePublic
+			Error string
+		}
+		if err := json.Unmarshal(out, &mod); err != nil {
+			lastErr = errBuf.String()
+			continue
+		}
+		if mod.Error != "" {
+			lastErr = mod.Error
+			continue
+		}
+
+		// the module must contain the package
+		pkgDir := filepath.Join(mod.Dir, filepath.FromSlash(strings.TrimPrefix(pkg, modPath)))
+		if _, err := os.Stat(pkgDir); err != nil {
+			lastErr = "module " + modPath + "@" + version + " found, but does not contain package " + pkg
+			continue
+		}
+
+		return &mod.ModulePublic
+	}
+
+	log.Fatalf("fail to download the module of %v@%v: %v", pkg, version, strings.TrimSpace(lastErr))
+	return nil
+}
+
+// checkModuleDirectives fails like the go command if the go.mod of the module has the replace or exclude
+// directives, which would make the module interpreted differently than if it were the main module.
+//
+// the module without go.mod has no directives.
+func checkModuleDirectives(mod *ModulePublic) {
+	if !fileExists(filepath.Join(mod.Dir, "go.mod")) {
+		return
+	}
+
+	cmd := exec.Command("go", "mod", "edit", "-json")
+	cmd.Dir = mod.Dir
+	out, err := cmd.Output()
+	if err != nil {
+		log.Fatalf("fail to read the go.mod: %v", err)
+	}
+
+	var gomod struct {
+		Replace []json.RawMessage
+		Exclude []json.RawMessage
+	}
+	if err := json.Unmarshal(out, &gomod); err != nil {
+		log.Fatalf("fail to parse the go.mod: %v", err)
+	}
+
+	directive := ""
+	switch {
+	case len(gomod.Replace) != 0:
+		directive = "replace"
+	case len(gomod.Exclude) != 0:
+		directive = "exclude"
+	default:
+		return
+	}
+
+	log.Fatalf("%v@%v (in %v@%v):\n"+
+		"\tThe go.mod file for the module providing named packages contains one or\n"+
+		"\tmore %v directives. It must not contain directives that would cause\n"+
+		"\tit to be interpreted differently than if it were the main module.",
+		mod.Path, mod.Version, mod.Path, mod.Version, directive)
+}
+
+// copyDir copies the directory tree, the files in the module cache are read-only,
+// so the permissions are not kept.
+func copyDir(src string, dst string) {
+	err := filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, relPath)
+
+		if d.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		s, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer s.Close()
+
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		_, err = io.Copy(f, s)
+		return err
+	})
+	if err != nil {
+		log.Fatalf("fail to copy %v to %v: %v", src, dst, err)
+	}
+}
@@ -0,0 +1,37 @@
+package compile
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckModuleDirectives(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go command not found")
+	}
+	t.Setenv("GOFLAGS", "")
+
+	tests := []struct {
+		name  string
+		gomod string
+	}{
+		{"no go.mod", ""},
+		{"no directives", "module example.com/tool\n\ngo 1.19\n\nrequire example.com/lib v1.0.0\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if tt.gomod != "" {
+				if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte(tt.gomod), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// it exits if the check fails
+			checkModuleDirectives(&ModulePublic{Path: "example.com/tool", Version: "v1.0.0", Dir: dir})
+		})
+	}
+}
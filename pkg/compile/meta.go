@@ -102,9 +102,19 @@ type PackageError struct {
 }
 
 func (c *Compile) readGoWork() string {
-	out, err := exec.Command("go", "env", "GOWORK").Output()
+	return readGoEnv(c.curWd, "GOWORK", c.moduleEnv...)
+}
+
+// readGoEnv reads the go environment variable by `go env` in the directory, with extra environment variables
+func readGoEnv(dir string, name string, env ...string) string {
+	cmd := exec.Command("go", "env", name)
+	cmd.Dir = dir
+	if len(env) != 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	out, err := cmd.Output()
 	if err != nil {
-		log.Fatalf("fail to read GOWORK: %v", err)
+		log.Fatalf("fail to read %v: %v", name, err)
 	}
 
 	return strings.TrimSpace(string(out))
@@ -113,6 +123,17 @@ func (c *Compile) readGoWork() string {
 func (c *Compile) readProjectMetaInfo() {
 	c.curGoWork = c.readGoWork()
 
+	// the go.work directory is not a module, the packages of the members are listed by readWorkModules
+	if c.goWorkEnabled() && c.curWd == filepath.Dir(c.curGoWork) && !fileExists(filepath.Join(c.curWd, "go.mod")) {
+		c.isWorkRoot = true
+		c.curProjectRootDir = c.curWd
+		c.pkgs = make(map[string]*Package)
+		c.isBuildModVendor = c.checkIfVendor()
+		c.readLocalModules()
+		log.Donef("project meta information parsed")
+		return
+	}
+
 	pkgs := c.listPackages(c.curWd)
 	for _, pkg := range pkgs {
 		// check if go mod is enabled
@@ -122,6 +143,7 @@ func (c *Compile) readProjectMetaInfo() {
 
 		c.curProjectRootDir = pkg.Module.Dir
 		c.projectModulePath = pkg.Module.Path
+		c.projectModuleDir = pkg.Module.Dir
 
 		// no need to loop each package
 		break
@@ -135,6 +157,11 @@ func (c *Compile) readProjectMetaInfo() {
 	}
 
 	c.isBuildModVendor = c.checkIfVendor()
+	if c.isBuildModVendor {
+		c.readVendorPackages()
+	}
+
+	c.readLocalModules()
 	log.Donef("project meta information parsed")
 }
 
@@ -150,22 +177,32 @@ func (c *Compile) displayProjectMetaInfo() {
 	}
 }
 
-// listPacakges uses `go list -json` command to get prjects meta information
-func (c *Compile) listPackages(dir string) map[string]*Package {
-	listArgs := []string{"list", "-json"}
-	if c.buildTags != "" {
-		listArgs = append(listArgs, "-tags", c.buildTags)
+// listPacakges uses `go list -json` command to get prjects meta information,
+// the patterns default to `./...`
+func (c *Compile) listPackages(dir string, patterns ...string) map[string]*Package {
+	return c.listPackagesWithEnv(dir, nil, patterns...)
+}
+
+// listPackagesWithEnv is the same as listPackages, with extra environment variables
+func (c *Compile) listPackagesWithEnv(dir string, env []string, patterns ...string) map[string]*Package {
+	if len(patterns) == 0 {
+		patterns = []string{"./..."}
 	}
-	listArgs = append(listArgs, "./...")
+
+	listArgs := []string{"list", "-json"}
+	listArgs = append(listArgs, c.listFlags...)
+	listArgs = append(listArgs, patterns...)
 
 	cmd := exec.Command("go", listArgs...)
 	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), c.target.env()...)
+	cmd.Env = append(append(cmd.Env, c.moduleEnv...), env...)
 
 	var errBuf bytes.Buffer
 	cmd.Stderr = &errBuf
 	out, err := cmd.Output()
 	if err != nil {
-		log.Fatalf("execute go list -json ./... failed, err: %v, stdout: %v, stderr: %v", err, string(out), errBuf.String())
+		log.Fatalf("execute go %v failed, err: %v, stdout: %v, stderr: %v", strings.Join(listArgs, " "), err, string(out), errBuf.String())
 	}
 
 	dec := json.NewDecoder(bytes.NewBuffer(out))
@@ -189,16 +226,58 @@ func (c *Compile) listPackages(dir string) map[string]*Package {
 	return pkgs
 }
 
-// checkIfVendor, check go mod type based on command line or vendor directory
+// checkIfVendor tells if the go command builds in the vendor mode, decided the same as the go command:
+// -mod in the command line, then -mod in GOFLAGS, then the vendor directory exists and the go version
+// in go.mod is 1.14 or later, or in go.work is 1.22 or later in the workspace mode.
 func (c *Compile) checkIfVendor() bool {
-	if c.buildMod == "vendor" {
-		return true
+	vendor := c.defaultVendor()
+	if vendor && c.goWorkEnabled() {
+		log.Fatalf("the vendor mode of the workspace is not supported")
 	}
 
-	vendorDir := filepath.Join(c.curProjectRootDir, "vendor")
-	if _, err := os.Stat(vendorDir); err != nil {
+	return vendor
+}
+
+func (c *Compile) defaultVendor() bool {
+	mod := c.buildMod
+	if mod == "" {
+		mod = goFlagsMod(readGoEnv(c.curWd, "GOFLAGS", c.moduleEnv...))
+	}
+	if mod != "" {
+		return mod == "vendor"
+	}
+
+	root, goVersion, minGoMinor := c.curProjectRootDir, "", vendorMinGoMinor
+	if c.goWorkEnabled() {
+		root, minGoMinor = filepath.Dir(c.curGoWork), workVendorMinGoMinor
+	}
+	if info, err := os.Stat(filepath.Join(root, VENDOR_DIR)); err != nil || !info.IsDir() {
 		return false
+	}
+
+	if c.goWorkEnabled() {
+		goVersion = readGoWorkJSON(c.curGoWork).Go
 	} else {
-		return true
+		goVersion = readGoModJSON(root).Go
 	}
+	minor, ok := minorVersion(goVersion)
+	if !ok || minor < minGoMinor {
+		log.Debugf("go version %q, the vendor directory is not used", goVersion)
+		return false
+	}
+
+	return true
+}
+
+// goFlagsMod returns the last -mod flag in GOFLAGS
+func goFlagsMod(goflags string) string {
+	mod := ""
+	for _, f := range strings.Fields(goflags) {
+		name, value, _ := splitFlag(f)
+		if name == "mod" {
+			mod = value
+		}
+	}
+
+	return mod
 }
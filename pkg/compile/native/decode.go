@@ -0,0 +1,218 @@
+package native
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+var (
+	errMalformed = errors.New("malformed coverage data")
+	errVersion   = errors.New("unknown version of the coverage data")
+)
+
+type nativeUnit struct {
+	file                                  string
+	stLine, stCol, enLine, enCol, nxStmts uint32
+}
+
+type nativeFunc struct {
+	units []nativeUnit
+}
+
+type byteReader struct {
+	b   []byte
+	off int
+	err error
+}
+
+func (r *byteReader) uleb() uint32 {
+	var v uint64
+	var shift uint
+	for {
+		if r.off >= len(r.b) {
+			r.err = errMalformed
+			return 0
+		}
+		c := r.b[r.off]
+		r.off++
+		v |= uint64(c&0x7f) << shift
+		if c&0x80 == 0 {
+			return uint32(v)
+		}
+		shift += 7
+	}
+}
+
+func (r *byteReader) u32(bigEndian bool) uint32 {
+	if r.off+4 > len(r.b) {
+		r.err = errMalformed
+		return 0
+	}
+	var v uint32
+	if bigEndian {
+		v = binary.BigEndian.Uint32(r.b[r.off:])
+	} else {
+		v = binary.LittleEndian.Uint32(r.b[r.off:])
+	}
+	r.off += 4
+	return v
+}
+
+func (r *byteReader) strings() []string {
+	n := r.uleb()
+	strs := make([]string, 0, n)
+	for i := uint32(0); i < n && r.err == nil; i++ {
+		l := int(r.uleb())
+		if r.off+l > len(r.b) {
+			r.err = errMalformed
+			break
+		}
+		strs = append(strs, string(r.b[r.off:r.off+l]))
+		r.off += l
+	}
+	return strs
+}
+
+const (
+	metaFileHeaderSize   = 56
+	metaSymbolHeaderSize = 44
+	counterHeaderSize    = 32
+	counterFooterSize    = 16
+	segmentHeaderSize    = 16
+
+	// metaFileVersion and counterFileVersion are the only versions known by the decoder
+	metaFileVersion    = 1
+	counterFileVersion = 1
+)
+
+var (
+	metaMagic    = []byte{0, 'c', 'v', 'm'}
+	counterMagic = []byte{0, 'c', 'w', 'm'}
+)
+
+// decodeMeta decodes the meta-data file, returns the functions of every package
+func decodeMeta(b []byte) ([][]nativeFunc, error) {
+	if len(b) < metaFileHeaderSize || !bytes.Equal(b[:4], metaMagic) {
+		return nil, errMalformed
+	}
+	le := binary.LittleEndian
+	if le.Uint32(b[4:8]) != metaFileVersion {
+		return nil, errVersion
+	}
+
+	entries := int(le.Uint64(b[16:24]))
+	if metaFileHeaderSize+16*entries > len(b) {
+		return nil, errMalformed
+	}
+
+	pkgs := make([][]nativeFunc, 0, entries)
+	for i := 0; i < entries; i++ {
+		off := le.Uint64(b[metaFileHeaderSize+8*i:])
+		length := le.Uint64(b[metaFileHeaderSize+8*entries+8*i:])
+		if off+length > uint64(len(b)) || length < metaSymbolHeaderSize {
+			return nil, errMalformed
+		}
+		payload := b[off : off+length]
+
+		numFuncs := int(le.Uint32(payload[40:44]))
+		r := &byteReader{b: payload, off: metaSymbolHeaderSize + 4*numFuncs}
+		strs := r.strings()
+
+		funcs := make([]nativeFunc, 0, numFuncs)
+		for f := 0; f < numFuncs && r.err == nil; f++ {
+			r.off = int(le.Uint32(payload[metaSymbolHeaderSize+4*f:]))
+			numUnits := r.uleb()
+			r.uleb() // function name
+			fileIdx := r.uleb()
+			if int(fileIdx) >= len(strs) {
+				return nil, errMalformed
+			}
+
+			fn := nativeFunc{units: make([]nativeUnit, 0, numUnits)}
+			for k := uint32(0); k < numUnits && r.err == nil; k++ {
+				fn.units = append(fn.units, nativeUnit{
+					file:    strs[fileIdx],
+					stLine:  r.uleb(),
+					stCol:   r.uleb(),
+					enLine:  r.uleb(),
+					enCol:   r.uleb(),
+					nxStmts: r.uleb(),
+				})
+			}
+			funcs = append(funcs, fn)
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+
+		pkgs = append(pkgs, funcs)
+	}
+
+	return pkgs, nil
+}
+
+// decodeCounters decodes the counter data file, returns the counters keyed by the package and function index
+func decodeCounters(b []byte) (map[[2]uint32][]uint32, error) {
+	if len(b) < counterHeaderSize+counterFooterSize || !bytes.Equal(b[:4], counterMagic) {
+		return nil, errMalformed
+	}
+	le := binary.LittleEndian
+	if le.Uint32(b[4:8]) != counterFileVersion {
+		return nil, errVersion
+	}
+
+	uleb := b[24] == 2
+	bigEndian := b[25] != 0
+
+	// the segments are followed by one footer at the end of the file
+	footer := b[len(b)-counterFooterSize:]
+	if !bytes.Equal(footer[:4], counterMagic) {
+		return nil, errMalformed
+	}
+	segments := le.Uint32(footer[8:12])
+	end := len(b) - counterFooterSize
+
+	counts := make(map[[2]uint32][]uint32)
+	r := &byteReader{b: b[:end], off: counterHeaderSize}
+	read := func() uint32 {
+		if uleb {
+			return r.uleb()
+		}
+		return r.u32(bigEndian)
+	}
+
+	for s := uint32(0); s < segments; s++ {
+		if r.off+segmentHeaderSize > end {
+			return nil, errMalformed
+		}
+		fcnEntries := le.Uint64(b[r.off:])
+		strTabLen := int(le.Uint32(b[r.off+8:]))
+		argsLen := int(le.Uint32(b[r.off+12:]))
+		r.off += segmentHeaderSize + strTabLen + argsLen
+		r.off = (r.off + 3) &^ 3
+
+		for f := uint64(0); f < fcnEntries && r.err == nil; f++ {
+			n := read()
+			key := [2]uint32{read(), read()}
+			ctrs := counts[key]
+			for k := uint32(0); k < n && r.err == nil; k++ {
+				v := read()
+				if int(k) < len(ctrs) {
+					ctrs[k] += v
+				} else {
+					ctrs = append(ctrs, v)
+				}
+			}
+			counts[key] = ctrs
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+	}
+	if r.off != end {
+		return nil, errMalformed
+	}
+
+	return counts, nil
+}
@@ -0,0 +1,249 @@
+package native
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// testFile is the file of package a in the profile
+const testFile = "example.com/m/a/a.go"
+
+const testSource = `package a
+
+func A(x int) int {
+	if x > 0 {
+		return 1
+	}
+	return 0
+}
+
+func B() {
+	println()
+}
+`
+
+const testMain = `package main
+
+import (
+	"os"
+	"runtime/coverage"
+
+	"example.com/m/a"
+)
+
+func main() {
+	a.A(1)
+	a.A(1)
+
+	meta, _ := os.Create(os.Args[1])
+	counters, _ := os.Create(os.Args[2])
+	if err := coverage.WriteMeta(meta); err != nil {
+		panic(err)
+	}
+	if err := coverage.WriteCounters(counters); err != nil {
+		panic(err)
+	}
+	meta.Close()
+	counters.Close()
+}
+`
+
+// writeCoverageData builds a program with -cover, and returns the meta-data and counter data
+// it writes by runtime/coverage, and the lines of package a in the profile converted by go tool covdata
+func writeCoverageData(t *testing.T) ([]byte, []byte, []string) {
+	t.Helper()
+
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go command not found")
+	}
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod":  "module example.com/m\n\ngo 1.20\n",
+		"a/a.go":  testSource,
+		"main.go": testMain,
+	}
+	for name, content := range files {
+		file := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	env := append(os.Environ(), "GOFLAGS=", "GOWORK=off")
+
+	// the main package is instrumented too, or there is no meta-data
+	bin := filepath.Join(dir, "m")
+	build := exec.Command("go", "build", "-cover", "-covermode=atomic", "-coverpkg=example.com/m,example.com/m/a", "-o", bin, ".")
+	build.Dir = dir
+	build.Env = env
+	if out, err := build.CombinedOutput(); err != nil {
+		t.Skipf("go build -cover is not supported: %v\n%s", err, out)
+	}
+
+	coverDir := filepath.Join(dir, "cover")
+	if err := os.Mkdir(coverDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	metaFile, countersFile := filepath.Join(dir, "meta"), filepath.Join(dir, "counters")
+	run := exec.Command(bin, metaFile, countersFile)
+	run.Env = append(env, "GOCOVERDIR="+coverDir)
+	if out, err := run.CombinedOutput(); err != nil {
+		t.Fatalf("fail to run: %v\n%s", err, out)
+	}
+
+	profileFile := filepath.Join(dir, "profile")
+	textfmt := exec.Command("go", "tool", "covdata", "textfmt", "-i="+coverDir, "-o="+profileFile)
+	textfmt.Env = env
+	if out, err := textfmt.CombinedOutput(); err != nil {
+		t.Fatalf("fail to convert the profile: %v\n%s", err, out)
+	}
+
+	meta, err := os.ReadFile(metaFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counters, err := os.ReadFile(countersFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile, err := os.ReadFile(profileFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := make([]string, 0)
+	for _, line := range strings.Split(string(profile), "\n") {
+		if strings.HasPrefix(line, testFile+":") {
+			lines = append(lines, line)
+		}
+	}
+	sort.Strings(lines)
+
+	return meta, counters, lines
+}
+
+func TestDecode(t *testing.T) {
+	meta, counters, want := writeCoverageData(t)
+
+	pkgs, err := decodeMeta(meta)
+	if err != nil {
+		t.Fatalf("decodeMeta: %v", err)
+	}
+	if len(pkgs) != 2 {
+		t.Fatalf("got %v packages, want 2", len(pkgs))
+	}
+	counts, err := decodeCounters(counters)
+	if err != nil {
+		t.Fatalf("decodeCounters: %v", err)
+	}
+
+	got := make([]string, 0)
+	funcs := 0
+	for pi, fns := range pkgs {
+		for fi, fn := range fns {
+			ctrs := counts[[2]uint32{uint32(pi), uint32(fi)}]
+			for k, u := range fn.units {
+				if u.file != testFile {
+					continue
+				}
+				if k == 0 {
+					funcs++
+				}
+				var n uint32
+				if k < len(ctrs) {
+					n = ctrs[k]
+				}
+				got = append(got, fmt.Sprintf("%s:%d.%d,%d.%d %d %d", u.file, u.stLine, u.stCol, u.enLine, u.enCol, u.nxStmts, n))
+			}
+		}
+	}
+	sort.Strings(got)
+
+	if funcs != 2 {
+		t.Errorf("got %v functions in package a, want 2", funcs)
+	}
+	if len(want) == 0 || !reflect.DeepEqual(got, want) {
+		t.Errorf("got:\n%v\nwant:\n%v", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	meta, counters, _ := writeCoverageData(t)
+
+	// modify returns a copy of b changed by f
+	modify := func(b []byte, f func(b []byte) []byte) []byte {
+		return f(append([]byte{}, b...))
+	}
+
+	tests := []struct {
+		name     string
+		meta     []byte
+		counters []byte
+		want     error
+	}{
+		{
+			name: "unknown meta version",
+			meta: modify(meta, func(b []byte) []byte { b[4] = 2; return b }),
+			want: errVersion,
+		},
+		{
+			name: "bad meta magic",
+			meta: modify(meta, func(b []byte) []byte { b[1] = 'x'; return b }),
+			want: errMalformed,
+		},
+		{
+			name: "truncated meta",
+			meta: meta[:metaFileHeaderSize-1],
+			want: errMalformed,
+		},
+		{
+			name:     "unknown counter version",
+			counters: modify(counters, func(b []byte) []byte { b[4] = 2; return b }),
+			want:     errVersion,
+		},
+		{
+			name:     "bad footer magic",
+			counters: modify(counters, func(b []byte) []byte { b[len(b)-counterFooterSize+1] = 'x'; return b }),
+			want:     errMalformed,
+		},
+		{
+			name: "one more segment",
+			counters: modify(counters, func(b []byte) []byte {
+				b[len(b)-counterFooterSize+8]++
+				return b
+			}),
+			want: errMalformed,
+		},
+		{
+			name: "trailing data before the footer",
+			counters: modify(counters, func(b []byte) []byte {
+				footer := append([]byte{}, b[len(b)-counterFooterSize:]...)
+				return append(append(b[:len(b)-counterFooterSize], 0, 0, 0, 0), footer...)
+			}),
+			want: errMalformed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var err error
+			if tt.meta != nil {
+				_, err = decodeMeta(tt.meta)
+			} else {
+				_, err = decodeCounters(tt.counters)
+			}
+			if err != tt.want {
+				t.Errorf("got error %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
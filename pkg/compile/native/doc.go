@@ -0,0 +1,5 @@
+// Package native decodes the meta-data and counter data files written by runtime/coverage,
+// see internal/coverage of the go source.
+//
+// decode.go is copied into the agent of the native backend, so it only imports the standard library.
+package native
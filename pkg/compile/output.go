@@ -0,0 +1,107 @@
+package compile
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/lyyyuna/gococo/pkg/log"
+)
+
+// cmdlineFilesPackage is the import path of the package of the go files in the command line
+const cmdlineFilesPackage = "command-line-arguments"
+
+// resolveOutput sets -o of build the same as the go command would do in the working directory,
+// as the go command runs in the cache directory.
+//
+// if -o is set, it is turned to the absolute path, the trailing separator is kept,
+// which tells the go command to write the main packages into the directory.
+//
+// if not, only a single main package is written to the working directory, named after the package,
+// the other packages are compiled but the results are discarded.
+func (c *Compile) resolveOutput() {
+	if c.compileType != GOCOCO_DO_BUILD {
+		return
+	}
+
+	if c.outputFlag != "" {
+		c.modifiedFlags = append(c.modifiedFlags, "-o", outputPath(c.curWd, c.outputFlag))
+		return
+	}
+
+	patterns := c.modifedArgs
+	if len(patterns) == 0 {
+		patterns = []string{"."}
+	}
+	pkgs := c.listPackages(c.curWd, patterns...)
+	if len(pkgs) != 1 {
+		log.Debugf("%v packages to build, no output is written", len(pkgs))
+		return
+	}
+
+	for _, pkg := range pkgs {
+		if pkg.Name != "main" {
+			log.Debugf("package %v is not a main package, no output is written", pkg.ImportPath)
+			return
+		}
+
+		name := defaultExecName(pkg) + c.target.exeSuffix()
+		output := filepath.Join(c.curWd, name)
+		if info, err := os.Stat(output); err == nil && info.IsDir() {
+			log.Fatalf("build output %q already exists and is a directory", name)
+		}
+		c.modifiedFlags = append(c.modifiedFlags, "-o", output)
+	}
+}
+
+// outputPath turns the -o flag to the absolute path, the null device is left as it is
+func outputPath(wd string, o string) string {
+	if o == os.DevNull {
+		return o
+	}
+
+	p := absPath(wd, o)
+	if strings.HasSuffix(o, "/") || strings.HasSuffix(o, string(os.PathSeparator)) {
+		p += string(os.PathSeparator)
+	}
+
+	return p
+}
+
+// defaultExecName returns the executable name of the main package, the same as the go command:
+// the first go file for the files in the command line, otherwise the last element of the import path,
+// without the major version suffix like /v2.
+func defaultExecName(pkg *Package) string {
+	if pkg.ImportPath == cmdlineFilesPackage {
+		files := pkg.GoFiles
+		if len(files) == 0 {
+			files = pkg.CgoFiles
+		}
+		if len(files) == 0 {
+			return ""
+		}
+		return strings.TrimSuffix(filepath.Base(files[0]), ".go")
+	}
+
+	dir, elem := path.Split(pkg.ImportPath)
+	if dir != "" && isVersionElement(elem) {
+		elem = path.Base(dir)
+	}
+
+	return elem
+}
+
+// isVersionElement tells if the path element is a major version suffix, like v2
+func isVersionElement(s string) bool {
+	if len(s) < 2 || s[0] != 'v' || s[1] == '0' || s[1] == '1' && len(s) == 2 {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		if s[i] < '0' || '9' < s[i] {
+			return false
+		}
+	}
+
+	return true
+}
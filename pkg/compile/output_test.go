@@ -0,0 +1,147 @@
+package compile
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestIsVersionElement(t *testing.T) {
+	tests := []struct {
+		elem string
+		want bool
+	}{
+		{"v2", true},
+		{"v10", true},
+		{"v1", false},
+		{"v0", false},
+		{"v01", false},
+		{"v", false},
+		{"v2a", false},
+		{"V2", false},
+		{"tool", false},
+	}
+
+	for _, tt := range tests {
+		if got := isVersionElement(tt.elem); got != tt.want {
+			t.Errorf("isVersionElement(%v) = %v, want %v", tt.elem, got, tt.want)
+		}
+	}
+}
+
+func TestDefaultExecName(t *testing.T) {
+	tests := []struct {
+		name string
+		pkg  Package
+		want string
+	}{
+		{"last element", Package{ImportPath: "example.com/tool/cmd/svc"}, "svc"},
+		{"major version", Package{ImportPath: "example.com/tool/v2"}, "tool"},
+		{"major version of a sub package", Package{ImportPath: "example.com/tool/v2/cmd/v3"}, "cmd"},
+		{"v1 is not a major version", Package{ImportPath: "example.com/tool/v1"}, "v1"},
+		{"single element", Package{ImportPath: "v2"}, "v2"},
+		{"first go file", Package{ImportPath: cmdlineFilesPackage, GoFiles: []string{"zmain.go", "alpha.go"}}, "zmain"},
+		{"first cgo file", Package{ImportPath: cmdlineFilesPackage, CgoFiles: []string{"c.go"}}, "c"},
+		{"no file", Package{ImportPath: cmdlineFilesPackage}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := defaultExecName(&tt.pkg); got != tt.want {
+				t.Errorf("defaultExecName = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveOutput(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go command not found")
+	}
+	t.Setenv("GOFLAGS", "")
+	t.Setenv("GOWORK", "off")
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod":     "module example.com/tool/v2\n\ngo 1.19\n",
+		"zmain.go":   "package main\n\nfunc main() { alpha() }\n",
+		"alpha.go":   "package main\n\nfunc alpha() {}\n",
+		"lib/lib.go": "package lib\n",
+	}
+	for name, content := range files {
+		file := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	linux := target{GOOS: "linux", GOARCH: "amd64", CGO_ENABLED: "0"}
+	windows := target{GOOS: "windows", GOARCH: "amd64", CGO_ENABLED: "0"}
+
+	tests := []struct {
+		name   string
+		args   []string
+		target target
+		output string
+		want   []string
+	}{
+		{
+			name:   "module root of a major version",
+			target: linux,
+			want:   []string{"-o", filepath.Join(dir, "tool")},
+		},
+		{
+			name:   "go files in the command line",
+			args:   []string{"zmain.go", "alpha.go"},
+			target: linux,
+			want:   []string{"-o", filepath.Join(dir, "zmain")},
+		},
+		{
+			name:   "windows",
+			args:   []string{"."},
+			target: windows,
+			want:   []string{"-o", filepath.Join(dir, "tool.exe")},
+		},
+		{
+			name:   "not a main package",
+			args:   []string{"./lib"},
+			target: linux,
+		},
+		{
+			name:   "several packages",
+			args:   []string{"./..."},
+			target: linux,
+		},
+		{
+			name:   "output flag",
+			args:   []string{"./lib"},
+			target: linux,
+			output: "out",
+			want:   []string{"-o", filepath.Join(dir, "out")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Compile{
+				compileType: GOCOCO_DO_BUILD,
+				curWd:       dir,
+				modifedArgs: tt.args,
+				target:      tt.target,
+				outputFlag:  tt.output,
+			}
+			c.resolveOutput()
+
+			if len(c.modifiedFlags) == 0 && len(tt.want) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(c.modifiedFlags, tt.want) {
+				t.Errorf("flags = %q, want %q", c.modifiedFlags, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package compile
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/lyyyuna/gococo/pkg/log"
+)
+
+// CACHE_OVERLAY is the -overlay file rewritten for the cache
+const CACHE_OVERLAY = "overlay.json"
+
+// goOverlay is the json file of the -overlay flag
+type goOverlay struct {
+	Replace map[string]string
+}
+
+// readOverlay reads the -overlay file, the relative paths in it are based on the working directory.
+//
+// the overlaid go files are neither instrumented nor have the main function renamed,
+// as the go command reads the replacements instead.
+func (c *Compile) readOverlay(file string) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		log.Fatalf("fail to read the overlay: %v", err)
+	}
+
+	var overlay goOverlay
+	if err := json.Unmarshal(content, &overlay); err != nil {
+		log.Fatalf("fail to parse the overlay %v: %v", file, err)
+	}
+
+	c.overlay = make(map[string]string, len(overlay.Replace))
+	for from, to := range overlay.Replace {
+		// the empty replacement means the file is deleted
+		if to != "" {
+			to = absPath(c.curWd, to)
+		}
+		c.overlay[absPath(c.curWd, from)] = to
+	}
+}
+
+// overlaid tells if the file is replaced by the overlay
+func (c *Compile) overlaid(file string) bool {
+	_, ok := c.overlay[file]
+	return ok
+}
+
+// overlayFile returns the overlay rewritten for the cache
+func (c *Compile) overlayFile() string {
+	return filepath.Join(c.cache.cacheRootDir, CACHE_OVERLAY)
+}
+
+// writeOverlay writes the overlay with the overlaid files mapped to the cache
+func (c *Compile) writeOverlay() {
+	overlay := goOverlay{
+		Replace: make(map[string]string, len(c.overlay)),
+	}
+	for from, to := range c.overlay {
+		overlay.Replace[c.cachedPath(from)] = to
+	}
+
+	content, err := json.MarshalIndent(overlay, "", "\t")
+	if err != nil {
+		log.Fatalf("fail to generate the overlay: %v", err)
+	}
+
+	if err := os.WriteFile(c.overlayFile(), content, 0644); err != nil {
+		log.Fatalf("fail to write the overlay: %v", err)
+	}
+}
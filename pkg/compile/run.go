@@ -0,0 +1,209 @@
+package compile
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/lyyyuna/gococo/pkg/agent"
+	"github.com/lyyyuna/gococo/pkg/log"
+)
+
+const (
+	// DEFAULT_COVERPROFILE is the default profile path of run, relative to the working directory
+	DEFAULT_COVERPROFILE = "gococo.cov"
+
+	// snapshotInterval is the interval to pull the profile from the running program
+	snapshotInterval = time.Second
+	// snapshotFirstInterval is the interval to pull the profile until the agent answers,
+	// not to miss the program which exits soon
+	snapshotFirstInterval = 20 * time.Millisecond
+)
+
+// newRun executes the built binary, with stdio forwarded,
+// and dumps the final coverage profile when the program exits.
+//
+// SIGTERM is always forwarded to the program, a duplicated one is harmless.
+// the program usually shares the process group with gococo, and receives Ctrl-C
+// from the terminal by itself, SIGINT is forwarded only if the program moves to
+// its own process group, as a second one may abort the graceful shutdown.
+//
+// the program flushes the profile itself when the main function returns.
+// if it exits in other ways, e.g., os.Exit or killed by a signal, the last
+// snapshot pulled from the agent is used. the profile of the last run is removed
+// first, if no profile is collected, run fails.
+func (c *Compile) newRun() {
+	profile := os.Getenv("GOCOCO_COVERPROFILE")
+	if profile == "" {
+		profile = DEFAULT_COVERPROFILE
+	}
+	if !filepath.IsAbs(profile) {
+		profile = filepath.Join(c.curWd, profile)
+	}
+	flushed := filepath.Join(filepath.Dir(c.runBinary), "flushed.cov")
+
+	// the profile of the last run must not be taken as this one
+	if err := os.Remove(profile); err != nil && !os.IsNotExist(err) {
+		log.Fatalf("fail to remove the old profile: %v", err)
+	}
+
+	agentAddr := os.Getenv("GOCOCO_AGENT_ADDR")
+	if agentAddr == "" {
+		agentAddr = c.agentAddr
+	}
+	if agentAddr == "" {
+		agentAddr = freeLocalAddr()
+	}
+
+	cmd := exec.Command(c.runBinary, c.programArgs...)
+	cmd.Env = append(os.Environ(),
+		"GOCOCO_AGENT_ADDR="+agentAddr,
+		"GOCOCO_COVERPROFILE="+flushed,
+	)
+	// the program built by the native backend warns if GOCOVERDIR is not set
+	if c.backend == BACKEND_NATIVE && os.Getenv("GOCOVERDIR") == "" {
+		cmd.Env = append(cmd.Env, "GOCOVERDIR="+filepath.Dir(c.runBinary))
+	}
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Start(); err != nil {
+		log.Fatalf("fail to start the program: %v", err)
+	}
+
+	snapshot := newSnapshotter(localAddr(agentAddr))
+	ctx, cancel := context.WithCancel(context.Background())
+	go snapshot.loop(ctx)
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	go func() {
+		for sig := range sigs {
+			// take a snapshot before the program goes away
+			snapshot.take()
+			if sig == os.Interrupt && !ownProcessGroup(cmd.Process.Pid) {
+				continue
+			}
+			if err := cmd.Process.Signal(sig); err != nil {
+				cmd.Process.Kill()
+			}
+		}
+	}()
+
+	err := cmd.Wait()
+	cancel()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			c.exitCode = exitErr.ExitCode()
+			// killed by a signal, follow the shell convention
+			if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+				c.exitCode = 128 + int(status.Signal())
+			}
+		} else {
+			log.Fatalf("fail to wait the program: %v", err)
+		}
+	}
+
+	data, err := os.ReadFile(flushed)
+	if err != nil {
+		log.Debugf("the program did not flush the profile, use the last snapshot")
+		data = snapshot.last()
+	}
+	if data == nil {
+		log.Errorf("no coverage profile collected from the program")
+		if c.exitCode == 0 {
+			c.exitCode = 1
+		}
+		return
+	}
+
+	if err := os.WriteFile(profile, data, 0644); err != nil {
+		log.Fatalf("fail to write the profile: %v", err)
+	}
+	log.Donef("coverage profile saved to: %v", profile)
+}
+
+// snapshotter pulls the profile from the agent of the running program periodically
+type snapshotter struct {
+	client *agent.Client
+
+	mu      sync.Mutex
+	profile []byte
+}
+
+func newSnapshotter(addr string) *snapshotter {
+	return &snapshotter{
+		client: agent.NewClient(addr, snapshotInterval),
+	}
+}
+
+// loop pulls the profile at once, and retries quickly until the agent answers
+func (s *snapshotter) loop(ctx context.Context) {
+	interval := snapshotFirstInterval
+	for {
+		if s.take() {
+			interval = snapshotInterval
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// take pulls the profile, returns false if the agent does not answer
+func (s *snapshotter) take() bool {
+	profile, err := s.client.Profile(context.Background())
+	if err != nil || !bytes.HasPrefix(profile, []byte("mode:")) {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.profile = profile
+
+	return true
+}
+
+func (s *snapshotter) last() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.profile
+}
+
+// freeLocalAddr picks a free port on the loopback interface
+func freeLocalAddr() string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		log.Fatalf("fail to find a free port for the agent: %v", err)
+	}
+	defer ln.Close()
+
+	return ln.Addr().String()
+}
+
+// localAddr turns the unspecified listen address, like `:7777`, to the loopback address
+func localAddr(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		return net.JoinHostPort("127.0.0.1", port)
+	}
+
+	return addr
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package compile
+
+// ownProcessGroup is false without the unix process groups,
+// the console signals are sent to all the attached processes.
+func ownProcessGroup(pid int) bool {
+	return false
+}
@@ -0,0 +1,15 @@
+//go:build unix
+
+package compile
+
+import "syscall"
+
+// ownProcessGroup tells if the process is not in the process group of gococo
+func ownProcessGroup(pid int) bool {
+	pgid, err := syscall.Getpgid(pid)
+	if err != nil {
+		return false
+	}
+
+	return pgid != syscall.Getpgrp()
+}
@@ -0,0 +1,178 @@
+package compile
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/lyyyuna/gococo/pkg/log"
+)
+
+// generatedRe matches the header of the generated go file, see https://go.dev/s/generatedcode
+var generatedRe = regexp.MustCompile(`^// Code generated .* DO NOT EDIT\.$`)
+
+// pkgPattern matches the import paths, it is compiled from the pattern in the form of:
+//
+//	example.com/app/internal/...	// `...` matches any string
+//	./internal/...					// relative to the working directory
+//	**/pb							// `**` matches any string, `*` matches any string without `/`
+type pkgPattern struct {
+	raw string
+	re  *regexp.Regexp
+}
+
+// compilePkgPatterns compiles the patterns separated by comma,
+// the relative patterns are resolved against the working directory.
+func (c *Compile) compilePkgPatterns(values []string) []*pkgPattern {
+	patterns := make([]*pkgPattern, 0)
+	for _, v := range values {
+		for _, raw := range strings.Split(v, ",") {
+			if raw = strings.TrimSpace(raw); raw == "" {
+				continue
+			}
+
+			patterns = append(patterns, &pkgPattern{
+				raw: raw,
+				re:  regexp.MustCompile(patternToRegexp(c.resolvePkgPattern(raw))),
+			})
+		}
+	}
+
+	return patterns
+}
+
+// resolvePkgPattern turns the relative pattern to the import path pattern
+func (c *Compile) resolvePkgPattern(raw string) string {
+	if raw != "." && raw != ".." && !strings.HasPrefix(raw, "./") && !strings.HasPrefix(raw, "../") {
+		return raw
+	}
+
+	root, modPath := c.curProjectRootDir, c.projectModulePath
+	dir := filepath.Join(c.curWd, filepath.FromSlash(raw))
+	if c.isWorkRoot {
+		root, modPath = c.workModuleOf(dir)
+		// the members of the go.work directory are all matched
+		if root == "" && dir == c.curProjectRootDir && strings.HasSuffix(raw, "/...") {
+			return "..."
+		}
+	}
+
+	rel, err := filepath.Rel(root, dir)
+	if root == "" || err != nil || !isSubPath(root, filepath.Join(root, rel)) {
+		log.Fatalf("the package pattern %v is outside the project", raw)
+	}
+
+	return path.Join(modPath, filepath.ToSlash(rel))
+}
+
+// workModuleOf returns the directory and the module path of the innermost go.work member containing the directory
+func (c *Compile) workModuleOf(dir string) (string, string) {
+	root, modPath := "", ""
+	for d, p := range c.workDirs {
+		if isSubPath(d, dir) && len(d) > len(root) {
+			root, modPath = d, p
+		}
+	}
+
+	return root, modPath
+}
+
+func patternToRegexp(pattern string) string {
+	// like go, `x/...` matches `x` too
+	if strings.HasSuffix(pattern, "/...") {
+		return "^" + globToRegexp(strings.TrimSuffix(pattern, "/...")) + "(/.*)?$"
+	}
+
+	return "^" + globToRegexp(pattern) + "$"
+}
+
+func globToRegexp(pattern string) string {
+	var b strings.Builder
+	for i := 0; i < len(pattern); {
+		switch {
+		case strings.HasPrefix(pattern[i:], "..."):
+			b.WriteString(".*")
+			i += 3
+		case strings.HasPrefix(pattern[i:], "**"):
+			b.WriteString(".*")
+			i += 2
+		case pattern[i] == '*':
+			b.WriteString("[^/]*")
+			i++
+		default:
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+			i++
+		}
+	}
+
+	return b.String()
+}
+
+func matchPkgPatterns(patterns []*pkgPattern, importPath string) bool {
+	for _, p := range patterns {
+		if p.re.MatchString(importPath) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// selected tells if the package is selected by --cover-pkg and --cover-exclude,
+// all the packages are selected if --cover-pkg is not set.
+func (c *Compile) selected(importPath string) bool {
+	if len(c.coverPkgs) != 0 && !matchPkgPatterns(c.coverPkgs, importPath) {
+		return false
+	}
+
+	return !matchPkgPatterns(c.coverExcludes, importPath)
+}
+
+// instrumentFiles returns the go files of the package to instrument, the overlaid files are skipped,
+// and the generated files are skipped unless --cover-generated is set.
+func (c *Compile) instrumentFiles(pkg *Package) []string {
+	files := make([]string, 0, len(pkg.GoFiles))
+	for _, f := range pkg.GoFiles {
+		if c.overlaid(filepath.Join(pkg.Dir, f)) {
+			log.Debugf("skip instrumenting the overlaid file: %v", path.Join(pkg.ImportPath, f))
+			continue
+		}
+		if c.coverGenerated {
+			files = append(files, f)
+			continue
+		}
+		if isGenerated(filepath.Join(pkg.Dir, f)) {
+			log.Debugf("skip instrumenting the generated file: %v", path.Join(pkg.ImportPath, f))
+			continue
+		}
+		files = append(files, f)
+	}
+
+	return files
+}
+
+// isGenerated tells if the go file has the generated header before the package clause
+func isGenerated(file string) bool {
+	f, err := os.Open(file)
+	if err != nil {
+		log.Fatalf("fail to open %v: %v", file, err)
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	s.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for s.Scan() {
+		line := strings.TrimRight(s.Text(), "\r")
+		if generatedRe.MatchString(line) {
+			return true
+		}
+		if strings.HasPrefix(line, "package ") {
+			return false
+		}
+	}
+
+	return false
+}
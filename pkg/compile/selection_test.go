@@ -0,0 +1,107 @@
+package compile
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+func TestPatternToRegexp(t *testing.T) {
+	tests := []struct {
+		pattern    string
+		importPath string
+		want       bool
+	}{
+		{"example.com/app/internal/...", "example.com/app/internal", true},
+		{"example.com/app/internal/...", "example.com/app/internal/a/b", true},
+		{"example.com/app/internal/...", "example.com/app/internalx", false},
+		{"example.com/app/...", "example.com/application", false},
+		{"example.com/.../pb", "example.com/app/api/pb", true},
+		{"example.com/.../pb", "example.com/app/api/pbx", false},
+		{"**/pb", "example.com/app/pb", true},
+		{"**/pb", "example.com/app/pb/v1", false},
+		{"**/pb/**", "example.com/app/pb/v1", true},
+		{"example.com/app/*/pb", "example.com/app/api/pb", true},
+		{"example.com/app/*/pb", "example.com/app/api/v1/pb", false},
+		{"example.com/app/mock*", "example.com/app/mocks", true},
+		{"example.com/app/mock*", "example.com/app/mocks/a", false},
+		{"example.com/app", "example.com/app", true},
+		{"example.com/app", "example.com/app/a", false},
+		{"example.com/a.b", "example.com/aXb", false},
+		{"example.com/a+b", "example.com/a+b", true},
+	}
+
+	for _, tt := range tests {
+		re := regexp.MustCompile(patternToRegexp(tt.pattern))
+		if got := re.MatchString(tt.importPath); got != tt.want {
+			t.Errorf("%v matches %v: got %v, want %v", tt.pattern, tt.importPath, got, tt.want)
+		}
+	}
+}
+
+func TestSelected(t *testing.T) {
+	root := filepath.FromSlash("/work/app")
+	c := &Compile{
+		curWd:             filepath.Join(root, "cmd"),
+		curProjectRootDir: root,
+		projectModulePath: "example.com/app",
+	}
+
+	tests := []struct {
+		name       string
+		coverPkgs  []string
+		excludes   []string
+		importPath string
+		want       bool
+	}{
+		{"all by default", nil, nil, "example.com/app/internal/a", true},
+		{"relative to the working directory", []string{"./..."}, nil, "example.com/app/cmd/svc", true},
+		{"relative outside the selection", []string{"./..."}, nil, "example.com/app/internal/a", false},
+		{"parent directory", []string{"../internal/..."}, nil, "example.com/app/internal/a", true},
+		{"comma separated", []string{"./svc,../internal/..."}, nil, "example.com/app/internal/a", true},
+		{"excluded", nil, []string{"**/pb"}, "example.com/app/internal/pb", false},
+		{"excluded wins", []string{"../internal/..."}, []string{"**/pb"}, "example.com/app/internal/pb", false},
+		{"not excluded", []string{"../internal/..."}, []string{"**/pb"}, "example.com/app/internal/pbx", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c.coverPkgs = c.compilePkgPatterns(tt.coverPkgs)
+			c.coverExcludes = c.compilePkgPatterns(tt.excludes)
+			if got := c.selected(tt.importPath); got != tt.want {
+				t.Errorf("selected(%v) = %v, want %v", tt.importPath, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsGenerated(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want bool
+	}{
+		{"protoc", "// Code generated by protoc-gen-go. DO NOT EDIT.\n\npackage pb\n", true},
+		{"after the license", "// Copyright 2024\n\n// Code generated by mockgen. DO NOT EDIT.\npackage mock\n", true},
+		{"crlf", "// Code generated by stringer. DO NOT EDIT.\r\npackage a\r\n", true},
+		{"plain file", "package a\n\nfunc A() {}\n", false},
+		{"after the package clause", "package a\n\n// Code generated by x. DO NOT EDIT.\n", false},
+		{"without the period", "// Code generated by x. DO NOT EDIT\npackage a\n", false},
+		{"not at the line start", "/* // Code generated by x. DO NOT EDIT. */\npackage a\n", false},
+		{"block comment", "/*\nCode generated by x. DO NOT EDIT.\n*/\npackage a\n", false},
+	}
+
+	dir := t.TempDir()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(dir, "a.go")
+			if err := os.WriteFile(file, []byte(tt.src), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if got := isGenerated(file); got != tt.want {
+				t.Errorf("isGenerated = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
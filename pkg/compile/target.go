@@ -0,0 +1,60 @@
+package compile
+
+import (
+	"encoding/json"
+	"os/exec"
+
+	"github.com/lyyyuna/gococo/pkg/log"
+)
+
+// target is the platform to build for, read by `go env`, so the GOOS and GOARCH
+// environment variables, `go env -w` and the default CGO_ENABLED are all taken into account.
+type target struct {
+	GOOS        string
+	GOARCH      string
+	GOHOSTOS    string
+	GOHOSTARCH  string
+	CGO_ENABLED string
+}
+
+// readTarget reads the target platform in the working directory
+func (c *Compile) readTarget() {
+	cmd := exec.Command("go", "env", "-json", "GOOS", "GOARCH", "GOHOSTOS", "GOHOSTARCH", "CGO_ENABLED")
+	cmd.Dir = c.curWd
+	out, err := cmd.Output()
+	if err != nil {
+		log.Fatalf("fail to read the target platform: %v", err)
+	}
+
+	if err := json.Unmarshal(out, &c.target); err != nil {
+		log.Fatalf("fail to parse the target platform: %v", err)
+	}
+
+	if c.target.cross() {
+		log.Infof("cross compiling for %v/%v, cgo enabled: %v", c.target.GOOS, c.target.GOARCH, c.target.CGO_ENABLED)
+	}
+}
+
+// cross tells if the target is different from the host
+func (t target) cross() bool {
+	return t.GOOS != t.GOHOSTOS || t.GOARCH != t.GOHOSTARCH
+}
+
+// env pins the target for the go commands, the package listing in the project
+// and the build in the cache see the same go files.
+func (t target) env() []string {
+	return []string{
+		"GOOS=" + t.GOOS,
+		"GOARCH=" + t.GOARCH,
+		"CGO_ENABLED=" + t.CGO_ENABLED,
+	}
+}
+
+// exeSuffix returns the suffix of the executable
+func (t target) exeSuffix() string {
+	if t.GOOS == "windows" {
+		return ".exe"
+	}
+
+	return ""
+}
@@ -0,0 +1,106 @@
+package compile
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lyyyuna/gococo/pkg/log"
+)
+
+// VENDOR_DIR is the vendor directory relative to the project root directory
+const VENDOR_DIR = "vendor"
+
+// the go version in go.mod, or go.work, from which the vendor directory is used by default
+const (
+	vendorMinGoMinor     = 14
+	workVendorMinGoMinor = 22
+)
+
+// readVendorPackages lists the vendored packages selected to instrument,
+// they are selected by --gococo-vendor-packages or GOCOCO_VENDOR_PACKAGES, the import path patterns.
+func (c *Compile) readVendorPackages() {
+	c.vendorPkgs = make(map[string]*Package)
+
+	patterns := splitList(c.vendorPkgFlags, "GOCOCO_VENDOR_PACKAGES")
+	if len(patterns) == 0 {
+		return
+	}
+
+	vendorDir := filepath.Join(c.curProjectRootDir, VENDOR_DIR)
+	for importPath, pkg := range c.listPackages(c.curProjectRootDir, patterns...) {
+		if pkg.Standard || !isSubPath(vendorDir, pkg.Dir) {
+			continue
+		}
+
+		c.vendorPkgs[importPath] = pkg
+		c.pkgs[importPath] = pkg
+		log.Debugf("vendored package %v selected to instrument", importPath)
+	}
+
+	if len(c.vendorPkgs) == 0 {
+		log.Warnf("no vendored package matches --gococo-vendor-packages: %v", strings.Join(patterns, ","))
+	}
+}
+
+// withVendor copies the whole vendor directory, including vendor/modules.txt
+func withVendor(enabled bool) cacheOption {
+	return func(bc *cache) {
+		if enabled {
+			bc.vendorDir = filepath.Join(bc.targetDir, VENDOR_DIR)
+		}
+	}
+}
+
+// vendorFiles returns all the files in the vendor directory
+func (bc *cache) vendorFiles() []string {
+	files := make([]string, 0)
+	if bc.vendorDir == "" {
+		return files
+	}
+
+	err := filepath.WalkDir(bc.vendorDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			files = append(files, p)
+		}
+
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		log.Fatalf("fail to walk the vendor directory: %v", err)
+	}
+
+	return files
+}
+
+// restoreVendored copies back the original go files of the vendored package
+// which is instrumented last time, but not selected this time.
+func (bc *cache) restoreVendored(importPath string) {
+	if bc.vendorDir == "" {
+		return
+	}
+
+	srcDir := filepath.Join(bc.vendorDir, filepath.FromSlash(importPath))
+	entries, err := os.ReadDir(srcDir)
+	if err != nil {
+		return
+	}
+
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".go") {
+			continue
+		}
+
+		src := filepath.Join(srcDir, e.Name())
+		if err := copyFile(src, bc.cachedPath(src)); err != nil {
+			log.Fatalf("fail to restore the file: %v", err)
+		}
+	}
+	os.Remove(filepath.Join(bc.cachedPath(srcDir), AGENT_REGISTER_FILE))
+
+	log.Debugf("vendored package %v is not instrumented any more, original files restored", importPath)
+}
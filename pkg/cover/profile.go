@@ -0,0 +1,254 @@
+package cover
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Profile represents the profiling data of a specific file
+type Profile struct {
+	FileName string
+	Mode     string
+	Blocks   []ProfileBlock
+}
+
+// ProfileBlock represents a single block of profiling data
+type ProfileBlock struct {
+	StartLine, StartCol int
+	EndLine, EndCol     int
+	NumStmt, Count      int
+}
+
+// blockKey identifies a block in a file
+type blockKey struct {
+	startLine, startCol int
+	endLine, endCol     int
+}
+
+func (b ProfileBlock) key() blockKey {
+	return blockKey{b.StartLine, b.StartCol, b.EndLine, b.EndCol}
+}
+
+func (k blockKey) less(o blockKey) bool {
+	if k.startLine != o.startLine {
+		return k.startLine < o.startLine
+	}
+	if k.startCol != o.startCol {
+		return k.startCol < o.startCol
+	}
+	if k.endLine != o.endLine {
+		return k.endLine < o.endLine
+	}
+	return k.endCol < o.endCol
+}
+
+// ParseProfiles parses the go coverage profile, the profiles are sorted by file name,
+// and the blocks of the same position in one file are merged.
+func ParseProfiles(r io.Reader) ([]*Profile, error) {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	files := make(map[string]*Profile)
+	mode := ""
+	for lineno := 1; s.Scan(); lineno++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" {
+			continue
+		}
+
+		if mode == "" {
+			const p = "mode: "
+			if !strings.HasPrefix(line, p) || line == p {
+				return nil, fmt.Errorf("line %v: bad mode line: %v", lineno, line)
+			}
+			mode = line[len(p):]
+			continue
+		}
+
+		// the profile merged by `cat` may contain several mode lines
+		if strings.HasPrefix(line, "mode: ") {
+			if line[len("mode: "):] != mode {
+				return nil, fmt.Errorf("line %v: inconsistent mode: %v", lineno, line)
+			}
+			continue
+		}
+
+		fileName, block, err := parseLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %w", lineno, err)
+		}
+
+		p := files[fileName]
+		if p == nil {
+			p = &Profile{
+				FileName: fileName,
+				Mode:     mode,
+			}
+			files[fileName] = p
+		}
+		p.Blocks = append(p.Blocks, block)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	profiles := make([]*Profile, 0, len(files))
+	for _, p := range files {
+		if err := p.mergeBlocks(); err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, p)
+	}
+	sort.Slice(profiles, func(i, j int) bool {
+		return profiles[i].FileName < profiles[j].FileName
+	})
+
+	return profiles, nil
+}
+
+// parseLine parses a line like `name.go:line.column,line.column numberOfStatements count`
+func parseLine(line string) (string, ProfileBlock, error) {
+	var b ProfileBlock
+
+	i := strings.LastIndex(line, ":")
+	if i < 0 {
+		return "", b, fmt.Errorf("bad profile line: %v", line)
+	}
+	fileName := line[:i]
+
+	fields := strings.Fields(line[i+1:])
+	if len(fields) != 3 {
+		return "", b, fmt.Errorf("bad profile line: %v", line)
+	}
+
+	var err error
+	if _, err = fmt.Sscanf(fields[0], "%d.%d,%d.%d", &b.StartLine, &b.StartCol, &b.EndLine, &b.EndCol); err != nil {
+		return "", b, fmt.Errorf("bad block position: %v", line)
+	}
+	if b.NumStmt, err = strconv.Atoi(fields[1]); err != nil {
+		return "", b, fmt.Errorf("bad statement number: %v", line)
+	}
+	if b.Count, err = strconv.Atoi(fields[2]); err != nil {
+		return "", b, fmt.Errorf("bad count: %v", line)
+	}
+
+	return fileName, b, nil
+}
+
+// mergeBlocks sorts the blocks by position and merges the blocks with the same position
+func (p *Profile) mergeBlocks() error {
+	sort.SliceStable(p.Blocks, func(i, j int) bool {
+		return p.Blocks[i].key().less(p.Blocks[j].key())
+	})
+
+	j := 1
+	for i := 1; i < len(p.Blocks); i++ {
+		b := p.Blocks[i]
+		last := &p.Blocks[j-1]
+		if b.key() != last.key() {
+			p.Blocks[j] = b
+			j++
+			continue
+		}
+
+		if b.NumStmt != last.NumStmt {
+			return fmt.Errorf("inconsistent statement number in %v:%d.%d,%d.%d",
+				p.FileName, b.StartLine, b.StartCol, b.EndLine, b.EndCol)
+		}
+		last.Count = mergeCount(p.Mode, last.Count, b.Count)
+	}
+	if len(p.Blocks) > 0 {
+		p.Blocks = p.Blocks[:j]
+	}
+
+	return nil
+}
+
+func mergeCount(mode string, a, b int) int {
+	if mode == "set" {
+		if a > 0 || b > 0 {
+			return 1
+		}
+		return 0
+	}
+
+	return a + b
+}
+
+// MergeProfiles merges several profiles into one, the modes of the profiles must be the same
+func MergeProfiles(profiles ...[]*Profile) ([]*Profile, error) {
+	files := make(map[string]*Profile)
+	mode := ""
+
+	for _, ps := range profiles {
+		for _, p := range ps {
+			if mode == "" {
+				mode = p.Mode
+			} else if p.Mode != mode {
+				return nil, fmt.Errorf("cannot merge profiles with different modes: %v and %v", mode, p.Mode)
+			}
+
+			merged := files[p.FileName]
+			if merged == nil {
+				merged = &Profile{
+					FileName: p.FileName,
+					Mode:     p.Mode,
+				}
+				files[p.FileName] = merged
+			}
+			merged.Blocks = append(merged.Blocks, p.Blocks...)
+		}
+	}
+
+	out := make([]*Profile, 0, len(files))
+	for _, p := range files {
+		if err := p.mergeBlocks(); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].FileName < out[j].FileName
+	})
+
+	return out, nil
+}
+
+// Signature returns the hash of the mode and the blocks of the profiles regardless of the counts,
+// the profiles of the binaries built from the same source have the same signature.
+func Signature(profiles []*Profile) string {
+	h := sha256.New()
+	for _, p := range profiles {
+		fmt.Fprintf(h, "%s %s\n", p.Mode, p.FileName)
+		for _, b := range p.Blocks {
+			fmt.Fprintf(h, "%d.%d,%d.%d %d\n", b.StartLine, b.StartCol, b.EndLine, b.EndCol, b.NumStmt)
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// WriteProfiles writes the profiles in the go coverage profile format
+func WriteProfiles(w io.Writer, profiles []*Profile) error {
+	mode := "atomic"
+	if len(profiles) != 0 {
+		mode = profiles[0].Mode
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "mode: %v\n", mode)
+	for _, p := range profiles {
+		for _, b := range p.Blocks {
+			fmt.Fprintf(bw, "%s:%d.%d,%d.%d %d %d\n", p.FileName,
+				b.StartLine, b.StartCol, b.EndLine, b.EndCol, b.NumStmt, b.Count)
+		}
+	}
+
+	return bw.Flush()
+}
@@ -0,0 +1,202 @@
+package cover
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func mustParse(t *testing.T, s string) []*Profile {
+	t.Helper()
+
+	ps, err := ParseProfiles(strings.NewReader(s))
+	if err != nil {
+		t.Fatalf("fail to parse the profile: %v", err)
+	}
+
+	return ps
+}
+
+func TestParseProfilesMergeBlocks(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "sorted by file and position",
+			profile: "mode: count\nb.go:3.1,4.2 1 1\na.go:5.1,6.2 1 0\na.go:1.1,2.2 2 3\n",
+			want:    "mode: count\na.go:1.1,2.2 2 3\na.go:5.1,6.2 1 0\nb.go:3.1,4.2 1 1\n",
+		},
+		{
+			name:    "same block is summed",
+			profile: "mode: count\na.go:1.1,2.2 2 3\na.go:1.1,2.2 2 4\n",
+			want:    "mode: count\na.go:1.1,2.2 2 7\n",
+		},
+		{
+			name:    "set mode",
+			profile: "mode: set\na.go:1.1,2.2 2 1\na.go:1.1,2.2 2 0\na.go:3.1,4.2 1 0\na.go:3.1,4.2 1 0\n",
+			want:    "mode: set\na.go:1.1,2.2 2 1\na.go:3.1,4.2 1 0\n",
+		},
+		{
+			name:    "profiles joined by cat",
+			profile: "mode: atomic\na.go:1.1,2.2 2 1\nmode: atomic\na.go:1.1,2.2 2 1\n",
+			want:    "mode: atomic\na.go:1.1,2.2 2 2\n",
+		},
+		{
+			name:    "inconsistent statement number",
+			profile: "mode: count\na.go:1.1,2.2 2 3\na.go:1.1,2.2 3 4\n",
+			wantErr: true,
+		},
+		{
+			name:    "inconsistent mode",
+			profile: "mode: count\na.go:1.1,2.2 2 3\nmode: set\n",
+			wantErr: true,
+		},
+		{
+			name:    "bad line",
+			profile: "mode: count\na.go:1.1,2.2 2\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps, err := ParseProfiles(strings.NewReader(tt.profile))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("want error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var buf bytes.Buffer
+			if err := WriteProfiles(&buf, ps); err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != tt.want {
+				t.Errorf("got:\n%v\nwant:\n%v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeProfiles(t *testing.T) {
+	tests := []struct {
+		name     string
+		profiles []string
+		want     string
+		wantErr  bool
+	}{
+		{
+			name: "counts are summed",
+			profiles: []string{
+				"mode: atomic\na.go:1.1,2.2 2 1\na.go:3.1,4.2 1 0\n",
+				"mode: atomic\na.go:1.1,2.2 2 2\na.go:3.1,4.2 1 5\n",
+			},
+			want: "mode: atomic\na.go:1.1,2.2 2 3\na.go:3.1,4.2 1 5\n",
+		},
+		{
+			name: "different files",
+			profiles: []string{
+				"mode: count\nb.go:1.1,2.2 1 1\n",
+				"mode: count\na.go:1.1,2.2 1 2\n",
+			},
+			want: "mode: count\na.go:1.1,2.2 1 2\nb.go:1.1,2.2 1 1\n",
+		},
+		{
+			name: "set mode",
+			profiles: []string{
+				"mode: set\na.go:1.1,2.2 2 1\n",
+				"mode: set\na.go:1.1,2.2 2 1\n",
+			},
+			want: "mode: set\na.go:1.1,2.2 2 1\n",
+		},
+		{
+			name: "different modes",
+			profiles: []string{
+				"mode: set\na.go:1.1,2.2 2 1\n",
+				"mode: count\na.go:1.1,2.2 2 1\n",
+			},
+			wantErr: true,
+		},
+		{
+			name: "different statement numbers",
+			profiles: []string{
+				"mode: count\na.go:1.1,2.2 2 1\n",
+				"mode: count\na.go:1.1,2.2 3 1\n",
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inputs := make([][]*Profile, 0, len(tt.profiles))
+			for _, p := range tt.profiles {
+				inputs = append(inputs, mustParse(t, p))
+			}
+
+			merged, err := MergeProfiles(inputs...)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("want error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var buf bytes.Buffer
+			if err := WriteProfiles(&buf, merged); err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != tt.want {
+				t.Errorf("got:\n%v\nwant:\n%v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeProfilesKeepsInputs(t *testing.T) {
+	a := mustParse(t, "mode: count\na.go:1.1,2.2 2 1\n")
+	b := mustParse(t, "mode: count\na.go:1.1,2.2 2 2\n")
+
+	if _, err := MergeProfiles(a, b); err != nil {
+		t.Fatal(err)
+	}
+	if a[0].Blocks[0].Count != 1 || b[0].Blocks[0].Count != 2 {
+		t.Errorf("the inputs are changed: %v, %v", a[0].Blocks, b[0].Blocks)
+	}
+}
+
+func TestSignature(t *testing.T) {
+	base := "mode: atomic\na.go:1.1,2.2 2 1\nb.go:3.1,4.2 1 0\n"
+
+	tests := []struct {
+		name    string
+		profile string
+		same    bool
+	}{
+		{"other counts", "mode: atomic\na.go:1.1,2.2 2 5\nb.go:3.1,4.2 1 3\n", true},
+		{"other order", "mode: atomic\nb.go:3.1,4.2 1 0\na.go:1.1,2.2 2 1\n", true},
+		{"other statement number", "mode: atomic\na.go:1.1,2.2 3 1\nb.go:3.1,4.2 1 0\n", false},
+		{"other position", "mode: atomic\na.go:1.1,2.3 2 1\nb.go:3.1,4.2 1 0\n", false},
+		{"less blocks", "mode: atomic\na.go:1.1,2.2 2 1\n", false},
+		{"other mode", "mode: count\na.go:1.1,2.2 2 1\nb.go:3.1,4.2 1 0\n", false},
+	}
+
+	want := Signature(mustParse(t, base))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Signature(mustParse(t, tt.profile)); (got == want) != tt.same {
+				t.Errorf("same signature = %v, want %v", got == want, tt.same)
+			}
+		})
+	}
+}
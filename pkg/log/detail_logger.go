@@ -35,9 +35,24 @@ func (l *detailLogger) Errorf(format string, args ...interface{}) {
 	l.logger.Sugar().Errorf(format, args...)
 }
 
+func (l *detailLogger) Infow(message string, fields ...Field) {
+	l.logger.Info(message, fields...)
+}
+
+func (l *detailLogger) Errorw(message string, fields ...Field) {
+	l.logger.Error(message, fields...)
+}
+
+func (l *detailLogger) Donew(message string, fields ...Field) {
+	l.logger.Info(message, fields...)
+}
+
 func (l *detailLogger) StartWait(message string) {
 }
 
+func (l *detailLogger) UpdateWait(message string) {
+}
+
 func (l *detailLogger) StopWait() {
 }
 
@@ -49,6 +64,13 @@ func (l *detailLogger) SetExitCallback(callback func()) {
 	l.exitCallback = callback
 }
 
+// ToStderr does nothing, the development logger of zap writes to stderr already
+func (l *detailLogger) ToStderr() {
+}
+
+func (l *detailLogger) SetPhase(phase string) {
+}
+
 func newDetailLogger() *detailLogger {
 	logger, _ := zap.NewDevelopment()
 	logger = logger.WithOptions(zap.AddCallerSkip(2))
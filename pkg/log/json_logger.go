@@ -0,0 +1,174 @@
+package log
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// jsonLogger writes one json object per line, for the machines like CI,
+// with the level, the timestamp, the phase and the extra fields.
+type jsonLogger struct {
+	mutex        sync.Mutex
+	logger       *zap.Logger
+	level        zapcore.Level
+	phase        string
+	waitMessage  string
+	waitStart    time.Time
+	exitCallback func()
+}
+
+func newJSONLogger(debug bool) *jsonLogger {
+	l := &jsonLogger{
+		level: zapcore.InfoLevel,
+	}
+	if debug {
+		l.level = zapcore.DebugLevel
+	}
+	l.build(os.Stdout)
+
+	return l
+}
+
+func (l *jsonLogger) build(w io.Writer) {
+	cfg := zap.NewProductionEncoderConfig()
+	cfg.TimeKey = "ts"
+	cfg.EncodeTime = zapcore.RFC3339NanoTimeEncoder
+
+	core := zapcore.NewCore(zapcore.NewJSONEncoder(cfg), zapcore.Lock(zapcore.AddSync(w)), l.level)
+	l.logger = zap.New(core)
+}
+
+func (l *jsonLogger) write(level zapcore.Level, msg string, fields ...zap.Field) {
+	if l.phase != "" {
+		fields = append(fields, zap.String("phase", l.phase))
+	}
+
+	if ce := l.logger.Check(level, msg); ce != nil {
+		ce.Write(fields...)
+	}
+}
+
+func (l *jsonLogger) Debugf(format string, args ...interface{}) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.write(zapcore.DebugLevel, fmt.Sprintf(format, args...))
+}
+
+func (l *jsonLogger) Donef(format string, args ...interface{}) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.write(zapcore.InfoLevel, fmt.Sprintf(format, args...), zap.Bool("done", true))
+}
+
+func (l *jsonLogger) Infof(format string, args ...interface{}) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.write(zapcore.InfoLevel, fmt.Sprintf(format, args...))
+}
+
+func (l *jsonLogger) Warnf(format string, args ...interface{}) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.write(zapcore.WarnLevel, fmt.Sprintf(format, args...))
+}
+
+func (l *jsonLogger) Errorf(format string, args ...interface{}) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.write(zapcore.ErrorLevel, fmt.Sprintf(format, args...))
+}
+
+func (l *jsonLogger) Fatalf(format string, args ...interface{}) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.exitCallback != nil {
+		l.exitCallback()
+	}
+
+	l.write(zapcore.FatalLevel, fmt.Sprintf(format, args...))
+	os.Exit(1)
+}
+
+func (l *jsonLogger) Infow(message string, fields ...Field) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.write(zapcore.InfoLevel, message, fields...)
+}
+
+func (l *jsonLogger) Errorw(message string, fields ...Field) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.write(zapcore.ErrorLevel, message, fields...)
+}
+
+func (l *jsonLogger) Donew(message string, fields ...Field) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.write(zapcore.InfoLevel, message, append(fields, zap.Bool("done", true))...)
+}
+
+// StartWait logs the start of the long running step, instead of the spinner
+func (l *jsonLogger) StartWait(message string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.waitMessage = message
+	l.waitStart = time.Now()
+	l.write(zapcore.InfoLevel, message, zap.String("wait", "start"))
+}
+
+func (l *jsonLogger) UpdateWait(message string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.write(zapcore.DebugLevel, message, zap.String("wait", "update"))
+}
+
+// StopWait logs the end of the long running step, with the elapsed time
+func (l *jsonLogger) StopWait() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.waitMessage == "" {
+		return
+	}
+	l.write(zapcore.InfoLevel, l.waitMessage, zap.String("wait", "stop"), zap.Duration("elapsed", time.Since(l.waitStart)))
+	l.waitMessage = ""
+}
+
+func (l *jsonLogger) Sync() {
+	l.logger.Sync()
+}
+
+func (l *jsonLogger) SetExitCallback(callback func()) {
+	l.exitCallback = callback
+}
+
+func (l *jsonLogger) ToStderr() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.build(os.Stderr)
+}
+
+func (l *jsonLogger) SetPhase(phase string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.phase = phase
+}
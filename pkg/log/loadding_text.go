@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/mgutz/ansi"
@@ -25,6 +26,8 @@ func getTermSize() (uint16, uint16, error) {
 }
 
 type loadingText struct {
+	// the message is updated while rendering
+	mutex          sync.Mutex
 	message        string
 	stream         io.Writer
 	stopChan       chan bool
@@ -52,11 +55,25 @@ func (l *loadingText) start() {
 	}()
 }
 
+func (l *loadingText) setMessage(message string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.message = message
+}
+
+func (l *loadingText) getMessage() string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.message
+}
+
 func (l *loadingText) stop() {
 	l.stopChan <- true
 	l.stream.Write([]byte("\r"))
 
-	for i := 0; i < len(l.message)+20; i++ {
+	for i := 0; i < len(l.getMessage())+20; i++ {
 		l.stream.Write([]byte(" "))
 	}
 
@@ -72,7 +89,7 @@ func (l *loadingText) render() {
 
 	timeElapsed := fmt.Sprintf("%v", (time.Now().UnixNano()-l.startTimestamp)/int64(time.Second))
 
-	message := []byte(l.getLoadingChar() + " " + l.message)
+	message := []byte(l.getLoadingChar() + " " + l.getMessage())
 
 	messageSuffix := " (" + timeElapsed + "s) "
 	suffixLength := len(messageSuffix)
@@ -1,19 +1,64 @@
 package log
 
+import (
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+// the output formats of the logger
+const (
+	FORMAT_TEXT = "text"
+	FORMAT_JSON = "json"
+)
+
+// the phases of the compile, recorded by the json logger
+const (
+	PHASE_PARSE      = "parse"
+	PHASE_COPY       = "copy"
+	PHASE_INSTRUMENT = "instrument"
+	PHASE_BUILD      = "build"
+	PHASE_RUN        = "run"
+)
+
 var g logger
 
+// debug, phase and exitCallback are kept for switching the format later
+var (
+	debug        bool
+	phase        string
+	exitCallback func()
+)
+
 func init() {
-	g = &terminalLogger{}
+	g = newTerminalLogger()
 }
 
-func NewLogger(debug bool) {
+func NewLogger(debugEnabled bool) {
+	debug = debugEnabled
 	if debug == true {
 		g = newDetailLogger()
 	} else {
-		g = &terminalLogger{}
+		g = newTerminalLogger()
 	}
 }
 
+// SetFormat switches the logger to the text or the json format
+func SetFormat(format string) error {
+	switch format {
+	case FORMAT_TEXT:
+		NewLogger(debug)
+	case FORMAT_JSON:
+		g = newJSONLogger(debug)
+	default:
+		return fmt.Errorf("unknown log format: %v, should be %v or %v", format, FORMAT_TEXT, FORMAT_JSON)
+	}
+	g.SetPhase(phase)
+	g.SetExitCallback(exitCallback)
+
+	return nil
+}
+
 func Donef(format string, args ...interface{}) {
 	g.Donef(format, args...)
 }
@@ -38,10 +83,43 @@ func Errorf(format string, args ...interface{}) {
 	g.Errorf(format, args...)
 }
 
+// Field is an extra key and value of the message, written by the json and the detail logger
+type Field = zap.Field
+
+// Strings returns the field of a string slice
+func Strings(key string, value []string) Field {
+	return zap.Strings(key, value)
+}
+
+// Int returns the field of an int
+func Int(key string, value int) Field {
+	return zap.Int(key, value)
+}
+
+// Infow logs the message with the fields
+func Infow(message string, fields ...Field) {
+	g.Infow(message, fields...)
+}
+
+// Errorw logs the message with the fields
+func Errorw(message string, fields ...Field) {
+	g.Errorw(message, fields...)
+}
+
+// Donew logs the message with the fields
+func Donew(message string, fields ...Field) {
+	g.Donew(message, fields...)
+}
+
 func StartWait(message string) {
 	g.StartWait(message)
 }
 
+// UpdateWait changes the message of the running spinner
+func UpdateWait(message string) {
+	g.UpdateWait(message)
+}
+
 func StopWait() {
 	g.StopWait()
 }
@@ -50,6 +128,19 @@ func Sync() {
 	g.Sync()
 }
 
+// SetExitCallback sets the function called before Fatalf exits
 func SetExitCallback(callback func()) {
+	exitCallback = callback
 	g.SetExitCallback(callback)
 }
+
+// ToStderr writes all the messages to stderr, to leave stdout for the output, e.g., json
+func ToStderr() {
+	g.ToStderr()
+}
+
+// SetPhase sets the phase of the following messages
+func SetPhase(p string) {
+	phase = p
+	g.SetPhase(p)
+}
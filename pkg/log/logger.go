@@ -13,11 +13,23 @@ type logger interface {
 
 	Donef(format string, args ...interface{})
 
+	Infow(message string, fields ...Field)
+
+	Errorw(message string, fields ...Field)
+
+	Donew(message string, fields ...Field)
+
 	StartWait(message string)
 
+	UpdateWait(message string)
+
 	StopWait()
 
 	Sync()
 
 	SetExitCallback(callback func())
+
+	ToStderr()
+
+	SetPhase(phase string)
 }
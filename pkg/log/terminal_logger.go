@@ -8,6 +8,7 @@ import (
 
 	goansi "github.com/k0kubun/go-ansi"
 	"github.com/mgutz/ansi"
+	"github.com/moby/term"
 	"go.uber.org/zap/zapcore"
 )
 
@@ -19,6 +20,20 @@ type terminalLogger struct {
 	level        zapcore.Level
 	loadingText  *loadingText
 	exitCallback func()
+	toStderr     bool
+	// plain disables the colours and the spinner, if the output is not a terminal
+	plain bool
+}
+
+func newTerminalLogger() *terminalLogger {
+	return &terminalLogger{
+		plain: !isTerminal(os.Stdout),
+	}
+}
+
+func isTerminal(f *os.File) bool {
+	_, ok := term.GetFdInfo(f)
+	return ok
 }
 
 type levelFuncType int32
@@ -30,6 +45,7 @@ const (
 	warnFn
 	debugFn
 	doneFn
+	waitFn
 )
 
 type levelFuncInfo struct {
@@ -70,6 +86,12 @@ var levelFuncMap = map[levelFuncType]*levelFuncInfo{
 		level:  zapcore.ErrorLevel,
 		stream: stdout,
 	},
+	waitFn: {
+		tag:    "[wait]   ",
+		color:  "cyan+b",
+		level:  zapcore.InfoLevel,
+		stream: stdout,
+	},
 	fatalFn: {
 		tag:    "[fatal]  ",
 		color:  "red+b",
@@ -85,8 +107,16 @@ func (t *terminalLogger) writeMessage(funcType levelFuncType, message string) {
 			t.loadingText.stop()
 		}
 
-		funcInfo.stream.Write([]byte(ansi.Color(funcInfo.tag, funcInfo.color)))
-		funcInfo.stream.Write([]byte(message))
+		stream := funcInfo.stream
+		if t.toStderr {
+			stream = stderr
+		}
+		if t.plain {
+			stream.Write([]byte(funcInfo.tag))
+		} else {
+			stream.Write([]byte(ansi.Color(funcInfo.tag, funcInfo.color)))
+		}
+		stream.Write([]byte(message))
 
 		if t.loadingText != nil && funcType != fatalFn {
 			t.loadingText.start()
@@ -103,14 +133,33 @@ func (t *terminalLogger) StartWait(message string) {
 		t.loadingText = nil
 	}
 
+	// no spinner, print the message only once
+	if t.plain {
+		t.writeMessage(waitFn, message+"\n")
+		return
+	}
+
+	var stream io.Writer = goansi.NewAnsiStdout()
+	if t.toStderr {
+		stream = stderr
+	}
 	t.loadingText = &loadingText{
 		message: message,
-		stream:  goansi.NewAnsiStdout(),
+		stream:  stream,
 	}
 
 	t.loadingText.start()
 }
 
+func (t *terminalLogger) UpdateWait(message string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.loadingText != nil {
+		t.loadingText.setMessage(message)
+	}
+}
+
 func (t *terminalLogger) StopWait() {
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
@@ -173,6 +222,39 @@ func (t *terminalLogger) Fatalf(format string, args ...interface{}) {
 	os.Exit(1)
 }
 
+// Infow, Errorw and Donew print the message only, the fields are for the machines
+func (t *terminalLogger) Infow(message string, fields ...Field) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.writeMessage(infoFn, message+"\n")
+}
+
+func (t *terminalLogger) Errorw(message string, fields ...Field) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.writeMessage(errorFn, message+"\n")
+}
+
+func (t *terminalLogger) Donew(message string, fields ...Field) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.writeMessage(doneFn, message+"\n")
+}
+
 func (t *terminalLogger) SetExitCallback(callback func()) {
 	t.exitCallback = callback
 }
+
+func (t *terminalLogger) ToStderr() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.toStderr = true
+	t.plain = !isTerminal(os.Stderr)
+}
+
+func (t *terminalLogger) SetPhase(phase string) {
+}
@@ -0,0 +1,181 @@
+package report
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/lyyyuna/gococo/pkg/log"
+	"github.com/lyyyuna/gococo/pkg/version"
+)
+
+// the cobertura xml format, see: http://cobertura.sourceforge.net/xml/coverage-04.dtd
+type coberturaCoverage struct {
+	XMLName         xml.Name           `xml:"coverage"`
+	LineRate        float64            `xml:"line-rate,attr"`
+	BranchRate      float64            `xml:"branch-rate,attr"`
+	LinesCovered    int                `xml:"lines-covered,attr"`
+	LinesValid      int                `xml:"lines-valid,attr"`
+	BranchesCovered int                `xml:"branches-covered,attr"`
+	BranchesValid   int                `xml:"branches-valid,attr"`
+	Complexity      float64            `xml:"complexity,attr"`
+	Version         string             `xml:"version,attr"`
+	Timestamp       int64              `xml:"timestamp,attr"`
+	Sources         []string           `xml:"sources>source"`
+	Packages        []coberturaPackage `xml:"packages>package"`
+}
+
+type coberturaPackage struct {
+	Name       string           `xml:"name,attr"`
+	LineRate   float64          `xml:"line-rate,attr"`
+	BranchRate float64          `xml:"branch-rate,attr"`
+	Complexity float64          `xml:"complexity,attr"`
+	Classes    []coberturaClass `xml:"classes>class"`
+}
+
+type coberturaClass struct {
+	Name       string            `xml:"name,attr"`
+	Filename   string            `xml:"filename,attr"`
+	LineRate   float64           `xml:"line-rate,attr"`
+	BranchRate float64           `xml:"branch-rate,attr"`
+	Complexity float64           `xml:"complexity,attr"`
+	Methods    []coberturaMethod `xml:"methods>method"`
+	Lines      []coberturaLine   `xml:"lines>line"`
+}
+
+type coberturaMethod struct {
+	Name       string          `xml:"name,attr"`
+	Signature  string          `xml:"signature,attr"`
+	LineRate   float64         `xml:"line-rate,attr"`
+	BranchRate float64         `xml:"branch-rate,attr"`
+	Complexity float64         `xml:"complexity,attr"`
+	Lines      []coberturaLine `xml:"lines>line"`
+}
+
+type coberturaLine struct {
+	Number int `xml:"number,attr"`
+	Hits   int `xml:"hits,attr"`
+}
+
+// lineCounter counts the valid and covered lines
+type lineCounter struct {
+	valid   int
+	covered int
+}
+
+func (c *lineCounter) add(lines []coberturaLine) {
+	for _, l := range lines {
+		c.valid++
+		if l.Hits > 0 {
+			c.covered++
+		}
+	}
+}
+
+func (c *lineCounter) rate() float64 {
+	if c.valid == 0 {
+		return 0
+	}
+	return float64(c.covered) / float64(c.valid)
+}
+
+// WriteCobertura writes the report in the cobertura xml format,
+// every package maps to a cobertura package, and every file maps to a class.
+func (r *Report) WriteCobertura(w io.Writer) error {
+	out := coberturaCoverage{
+		Version:   version.Version,
+		Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+	}
+	if dir := r.resolver.Dir(); dir != "" {
+		out.Sources = []string{dir}
+	}
+
+	var total lineCounter
+	for _, pkg := range r.Packages {
+		var pkgCounter lineCounter
+		cp := coberturaPackage{
+			Name: pkg.ImportPath,
+		}
+
+		for _, f := range pkg.Files {
+			lines, hits := lineHits(f.Profile)
+
+			class := coberturaClass{
+				Name:     strings.TrimSuffix(filepath.Base(f.FileName), ".go"),
+				Filename: r.relPath(f),
+			}
+			for _, l := range lines {
+				class.Lines = append(class.Lines, coberturaLine{Number: l, Hits: hits[l]})
+			}
+
+			if f.Path != "" {
+				funcs, err := funcCovers(f.Path, f.Profile)
+				if err != nil {
+					log.Warnf("fail to parse the functions of %v: %v", f.Path, err)
+				}
+				for _, fn := range funcs {
+					method := coberturaMethod{
+						Name: fn.name,
+					}
+					for _, l := range lines {
+						if l >= fn.startLine && l <= fn.endLine {
+							method.Lines = append(method.Lines, coberturaLine{Number: l, Hits: hits[l]})
+						}
+					}
+					var c lineCounter
+					c.add(method.Lines)
+					method.LineRate = c.rate()
+					class.Methods = append(class.Methods, method)
+				}
+			}
+
+			var c lineCounter
+			c.add(class.Lines)
+			class.LineRate = c.rate()
+			pkgCounter.add(class.Lines)
+
+			cp.Classes = append(cp.Classes, class)
+		}
+
+		cp.LineRate = pkgCounter.rate()
+		total.valid += pkgCounter.valid
+		total.covered += pkgCounter.covered
+
+		out.Packages = append(out.Packages, cp)
+	}
+
+	out.LinesValid = total.valid
+	out.LinesCovered = total.covered
+	out.LineRate = total.rate()
+
+	if _, err := fmt.Fprintf(w, "%v<!DOCTYPE coverage SYSTEM \"http://cobertura.sourceforge.net/xml/coverage-04.dtd\">\n", xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(out); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprintln(w)
+	return err
+}
+
+// relPath returns the path of the source file relative to the project directory,
+// the file name in the profile is returned if it is not resolved.
+func (r *Report) relPath(f *FileSummary) string {
+	if f.Path == "" {
+		return f.FileName
+	}
+
+	rel, err := filepath.Rel(r.resolver.Dir(), f.Path)
+	if err != nil {
+		return filepath.ToSlash(f.Path)
+	}
+
+	return filepath.ToSlash(rel)
+}
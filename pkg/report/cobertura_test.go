@@ -0,0 +1,135 @@
+package report
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+func TestWriteCobertura(t *testing.T) {
+	type class struct {
+		pkg      string
+		name     string
+		filename string
+		lines    int
+		lineRate float64
+		methods  map[string]float64 // method name -> line rate
+	}
+
+	tests := []struct {
+		name         string
+		profile      string
+		linesValid   int
+		linesCovered int
+		classes      []class
+	}{
+		{
+			name:         "resolved file with functions",
+			profile:      testProfile,
+			linesValid:   4,
+			linesCovered: 2,
+			classes: []class{
+				{
+					pkg:      "example.com/m/shape",
+					name:     "shape",
+					filename: "shape/shape.go",
+					lines:    4,
+					lineRate: 2.0 / 4,
+					methods:  map[string]float64{"Area": 2.0 / 3, "Zero": 0},
+				},
+			},
+		},
+		{
+			name:         "several packages and source not found",
+			profile:      testProfile + "example.com/m/gone.go:1.1,2.9 1 1\n",
+			linesValid:   6,
+			linesCovered: 4,
+			classes: []class{
+				{
+					pkg:      "example.com/m",
+					name:     "gone",
+					filename: "example.com/m/gone.go",
+					lines:    2,
+					lineRate: 1,
+					methods:  map[string]float64{},
+				},
+				{
+					pkg:      "example.com/m/shape",
+					name:     "shape",
+					filename: "shape/shape.go",
+					lines:    4,
+					lineRate: 2.0 / 4,
+					methods:  map[string]float64{"Area": 2.0 / 3, "Zero": 0},
+				},
+			},
+		},
+		{
+			name:    "empty",
+			profile: "mode: atomic\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, dir := newTestReport(t, tt.profile)
+
+			var buf bytes.Buffer
+			if err := r.WriteCobertura(&buf); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(buf.String(), "<!DOCTYPE coverage SYSTEM") {
+				t.Errorf("no doctype:\n%v", buf.String())
+			}
+
+			var got coberturaCoverage
+			if err := xml.Unmarshal(buf.Bytes(), &got); err != nil {
+				t.Fatalf("invalid xml: %v\n%v", err, buf.String())
+			}
+
+			if len(got.Sources) != 1 || got.Sources[0] != dir {
+				t.Errorf("sources = %v, want [%v]", got.Sources, dir)
+			}
+			if got.LinesValid != tt.linesValid || got.LinesCovered != tt.linesCovered {
+				t.Errorf("lines valid/covered = %v/%v, want %v/%v", got.LinesValid, got.LinesCovered, tt.linesValid, tt.linesCovered)
+			}
+
+			classes := make([]coberturaClass, 0)
+			pkgs := make([]string, 0)
+			for _, p := range got.Packages {
+				for _, c := range p.Classes {
+					classes = append(classes, c)
+					pkgs = append(pkgs, p.Name)
+				}
+			}
+			if len(classes) != len(tt.classes) {
+				t.Fatalf("got %v classes, want %v", len(classes), len(tt.classes))
+			}
+
+			for i, want := range tt.classes {
+				c := classes[i]
+				if pkgs[i] != want.pkg || c.Name != want.name || c.Filename != want.filename {
+					t.Errorf("class %v = %v %v %v, want %v %v %v", i, pkgs[i], c.Name, c.Filename, want.pkg, want.name, want.filename)
+				}
+				if len(c.Lines) != want.lines || !approx(c.LineRate, want.lineRate) {
+					t.Errorf("class %v: %v lines, line rate %v, want %v, %v", c.Name, len(c.Lines), c.LineRate, want.lines, want.lineRate)
+				}
+
+				if len(c.Methods) != len(want.methods) {
+					t.Errorf("class %v: got %v methods, want %v", c.Name, len(c.Methods), len(want.methods))
+				}
+				for _, m := range c.Methods {
+					rate, ok := want.methods[m.Name]
+					if !ok || !approx(m.LineRate, rate) {
+						t.Errorf("method %v: line rate %v, want %v", m.Name, m.LineRate, rate)
+					}
+				}
+			}
+		})
+	}
+}
+
+func approx(a float64, b float64) bool {
+	d := a - b
+	return d < 1e-9 && d > -1e-9
+}
@@ -0,0 +1,152 @@
+package report
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"sort"
+
+	"github.com/lyyyuna/gococo/pkg/cover"
+)
+
+// funcExtent describes a function's extent in the source by file and position
+type funcExtent struct {
+	name      string
+	startLine int
+	startCol  int
+	endLine   int
+	endCol    int
+}
+
+// funcCover is the coverage of a function
+type funcCover struct {
+	*funcExtent
+	// hits is the count of the entry block, i.e., how many times the function is called
+	hits int
+}
+
+// findFuncs parses the source file and returns the extents of the functions
+func findFuncs(path string) ([]*funcExtent, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, path, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]*funcExtent, 0)
+	for _, decl := range f.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Body == nil {
+			continue
+		}
+
+		start := fset.Position(fn.Pos())
+		end := fset.Position(fn.End())
+		out = append(out, &funcExtent{
+			name:      funcName(fn),
+			startLine: start.Line,
+			startCol:  start.Column,
+			endLine:   end.Line,
+			endCol:    end.Column,
+		})
+	}
+
+	return out, nil
+}
+
+// funcName returns the name of the function, like `F`, `T.M` or `(*T).M`
+func funcName(fn *ast.FuncDecl) string {
+	if fn.Recv == nil || len(fn.Recv.List) == 0 {
+		return fn.Name.Name
+	}
+
+	typ := fn.Recv.List[0].Type
+	ptr := false
+	if star, ok := typ.(*ast.StarExpr); ok {
+		ptr = true
+		typ = star.X
+	}
+	// strip the type parameters of generic types
+	switch t := typ.(type) {
+	case *ast.IndexExpr:
+		typ = t.X
+	case *ast.IndexListExpr:
+		typ = t.X
+	}
+
+	name := fmt.Sprintf("%v", typ)
+	if ident, ok := typ.(*ast.Ident); ok {
+		name = ident.Name
+	}
+
+	if ptr {
+		return fmt.Sprintf("(*%v).%v", name, fn.Name.Name)
+	}
+	return fmt.Sprintf("%v.%v", name, fn.Name.Name)
+}
+
+// contains tells if the block is inside the function
+func (f *funcExtent) contains(b cover.ProfileBlock) bool {
+	if b.StartLine < f.startLine || b.StartLine == f.startLine && b.StartCol < f.startCol {
+		return false
+	}
+	if b.EndLine > f.endLine || b.EndLine == f.endLine && b.EndCol > f.endCol {
+		return false
+	}
+	return true
+}
+
+// funcCovers calculates the coverage of every function in the file
+func funcCovers(path string, p *cover.Profile) ([]*funcCover, error) {
+	extents, err := findFuncs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]*funcCover, 0, len(extents))
+	for _, e := range extents {
+		fc := &funcCover{funcExtent: e}
+
+		// the blocks are sorted by position, the first one is the entry block
+		for _, b := range p.Blocks {
+			if e.contains(b) {
+				fc.hits = b.Count
+				break
+			}
+		}
+
+		out = append(out, fc)
+	}
+
+	return out, nil
+}
+
+// lineHits returns the hit count of every line which contains statements,
+// if several blocks share one line, the maximum count is used.
+func lineHits(p *cover.Profile) ([]int, map[int]int) {
+	hits := make(map[int]int)
+	for _, b := range p.Blocks {
+		if b.NumStmt == 0 {
+			continue
+		}
+		// the block ending at the first column does not cover its end line
+		end := b.EndLine
+		if b.EndCol <= 1 && end > b.StartLine {
+			end--
+		}
+		for l := b.StartLine; l <= end; l++ {
+			if c, ok := hits[l]; !ok || b.Count > c {
+				hits[l] = b.Count
+			}
+		}
+	}
+
+	lines := make([]int, 0, len(hits))
+	for l := range hits {
+		lines = append(lines, l)
+	}
+	sort.Ints(lines)
+
+	return lines, hits
+}
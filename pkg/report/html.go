@@ -0,0 +1,239 @@
+package report
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"os"
+	"sort"
+
+	"github.com/lyyyuna/gococo/pkg/cover"
+)
+
+// WriteHTML writes the report as a html page, with the annotated source of every file,
+// the file whose source can't be read gets a "source not found" section instead.
+func (r *Report) WriteHTML(w io.Writer) error {
+	data := htmlData{
+		Summary: r.Summary,
+	}
+
+	for _, pkg := range r.Packages {
+		for _, f := range pkg.Files {
+			hf := htmlFile{
+				Name:    f.FileName,
+				Path:    f.Path,
+				Summary: f.Summary,
+			}
+
+			if f.Path != "" {
+				if src, err := os.ReadFile(f.Path); err == nil {
+					var buf bytes.Buffer
+					htmlGen(&buf, src, f.Profile)
+					hf.Body = template.HTML(buf.String())
+				}
+			}
+
+			data.Files = append(data.Files, hf)
+		}
+	}
+
+	return htmlTmpl.Execute(w, data)
+}
+
+type htmlData struct {
+	Files []htmlFile
+	Summary
+}
+
+type htmlFile struct {
+	Name string
+	Path string
+	Body template.HTML
+	Summary
+}
+
+// boundary represents the start or end of a block
+type boundary struct {
+	offset int
+	start  bool
+	count  int
+	norm   float64
+}
+
+// boundaries converts the blocks to the offsets in the source
+func boundaries(src []byte, p *cover.Profile) []boundary {
+	max := 0
+	for _, b := range p.Blocks {
+		if b.Count > max {
+			max = b.Count
+		}
+	}
+	divisor := math.Log(float64(max))
+
+	index := 0
+	line, col := 1, 2
+	out := make([]boundary, 0, len(p.Blocks)*2)
+	add := func(offset int, start bool, count int) {
+		b := boundary{offset: offset, start: start, count: count}
+		if start && count > 0 {
+			if max <= 1 {
+				b.norm = 0.8
+			} else {
+				b.norm = math.Log(float64(count)) / divisor
+			}
+		}
+		out = append(out, b)
+	}
+
+	for si := 0; si < len(src) && index < len(p.Blocks); {
+		b := p.Blocks[index]
+		if b.StartLine == line && b.StartCol == col {
+			add(si, true, b.Count)
+		}
+		if b.EndLine == line && b.EndCol == col || line > b.EndLine {
+			add(si, false, 0)
+			index++
+			continue
+		}
+		if src[si] == '\n' {
+			line++
+			col = 0
+		}
+		col++
+		si++
+	}
+
+	// the end boundary goes before the start boundary at the same offset
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].offset == out[j].offset {
+			return !out[i].start && out[j].start
+		}
+		return out[i].offset < out[j].offset
+	})
+
+	return out
+}
+
+// htmlGen generates the html of the source with the coverage annotation,
+// it is the same as what `go tool cover -html` does.
+func htmlGen(w *bytes.Buffer, src []byte, p *cover.Profile) {
+	bs := boundaries(src, p)
+	for i := range src {
+		for len(bs) > 0 && bs[0].offset == i {
+			b := bs[0]
+			if b.start {
+				n := 0
+				if b.count > 0 {
+					n = int(math.Floor(b.norm*9)) + 1
+				}
+				fmt.Fprintf(w, `<span class="cov%v" title="%v">`, n, b.count)
+			} else {
+				w.WriteString("</span>")
+			}
+			bs = bs[1:]
+		}
+
+		switch c := src[i]; c {
+		case '>':
+			w.WriteString("&gt;")
+		case '<':
+			w.WriteString("&lt;")
+		case '&':
+			w.WriteString("&amp;")
+		case '\t':
+			w.WriteString("        ")
+		default:
+			w.WriteByte(c)
+		}
+	}
+
+	for range bs {
+		w.WriteString("</span>")
+	}
+}
+
+var htmlTmpl = template.Must(template.New("html").Funcs(template.FuncMap{
+	"percent": func(s Summary) string {
+		return fmt.Sprintf("%.1f%%", s.Percent())
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta http-equiv="Content-Type" content="text/html; charset=utf-8">
+<title>gococo coverage report</title>
+<style>
+body { background: black; color: rgb(80, 80, 80); }
+body, pre, #legend span { font-family: Menlo, monospace; font-weight: bold; }
+#topbar { background: black; position: fixed; top: 0; left: 0; right: 0; height: 42px; border-bottom: 1px solid rgb(80, 80, 80); }
+#content { margin-top: 50px; }
+#nav, #legend { float: left; margin-left: 10px; }
+#legend { margin-top: 12px; }
+#nav { margin-top: 10px; }
+#legend span { margin: 0 5px; }
+.cov0 { color: rgb(192, 0, 0) }
+.cov1 { color: rgb(128, 128, 128) }
+.cov2 { color: rgb(116, 140, 131) }
+.cov3 { color: rgb(104, 152, 134) }
+.cov4 { color: rgb(92, 164, 137) }
+.cov5 { color: rgb(80, 176, 140) }
+.cov6 { color: rgb(68, 188, 143) }
+.cov7 { color: rgb(56, 200, 146) }
+.cov8 { color: rgb(44, 212, 149) }
+.cov9 { color: rgb(32, 224, 152) }
+.cov10 { color: rgb(20, 236, 155) }
+</style>
+</head>
+<body>
+<div id="topbar">
+	<div id="nav">
+		<select id="files">
+		{{range $i, $f := .Files}}
+		<option value="file{{$i}}">{{$f.Name}} ({{percent $f.Summary}})</option>
+		{{end}}
+		</select>
+	</div>
+	<div id="legend">
+		<span>total: {{percent .Summary}}</span>
+		<span class="cov0">not covered</span>
+		<span class="cov8">covered</span>
+	</div>
+</div>
+<div id="content">
+{{range $i, $f := .Files}}
+{{if $f.Body}}<pre class="file" id="file{{$i}}" style="display: none">{{$f.Body}}</pre>
+{{else}}<pre class="file" id="file{{$i}}" style="display: none">source file not found: {{or $f.Path $f.Name}}</pre>
+{{end}}
+{{end}}
+</div>
+</body>
+<script>
+(function() {
+	var files = document.getElementById('files');
+	var visible;
+	files.addEventListener('change', onChange, false);
+	function select(part) {
+		if (visible)
+			visible.style.display = 'none';
+		visible = document.getElementById(part);
+		if (!visible)
+			return;
+		files.value = part;
+		visible.style.display = 'block';
+		location.hash = part;
+	}
+	function onChange() {
+		select(files.value);
+		window.scrollTo(0, 0);
+	}
+	if (location.hash != "") {
+		select(location.hash.substr(1));
+	}
+	if (!visible) {
+		select("file0");
+	}
+})();
+</script>
+</html>
+`))
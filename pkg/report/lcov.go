@@ -0,0 +1,61 @@
+package report
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+
+	"github.com/lyyyuna/gococo/pkg/log"
+)
+
+// WriteLCOV writes the report in the lcov tracefile format,
+// with the FN/FNDA records of the functions parsed from the source files.
+func (r *Report) WriteLCOV(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	for _, pkg := range r.Packages {
+		for _, f := range pkg.Files {
+			fmt.Fprintf(bw, "TN:\n")
+
+			sf := f.Path
+			if sf == "" {
+				sf = f.FileName
+			}
+			fmt.Fprintf(bw, "SF:%v\n", sf)
+
+			if f.Path != "" {
+				funcs, err := funcCovers(f.Path, f.Profile)
+				if err != nil {
+					log.Warnf("fail to parse the functions of %v: %v", f.Path, err)
+				}
+
+				hit := 0
+				for _, fn := range funcs {
+					fmt.Fprintf(bw, "FN:%v,%v\n", fn.startLine, fn.name)
+				}
+				for _, fn := range funcs {
+					fmt.Fprintf(bw, "FNDA:%v,%v\n", fn.hits, fn.name)
+					if fn.hits > 0 {
+						hit++
+					}
+				}
+				fmt.Fprintf(bw, "FNF:%v\n", len(funcs))
+				fmt.Fprintf(bw, "FNH:%v\n", hit)
+			}
+
+			lines, hits := lineHits(f.Profile)
+			covered := 0
+			for _, l := range lines {
+				fmt.Fprintf(bw, "DA:%v,%v\n", l, hits[l])
+				if hits[l] > 0 {
+					covered++
+				}
+			}
+			fmt.Fprintf(bw, "LF:%v\n", len(lines))
+			fmt.Fprintf(bw, "LH:%v\n", covered)
+			fmt.Fprintf(bw, "end_of_record\n")
+		}
+	}
+
+	return bw.Flush()
+}
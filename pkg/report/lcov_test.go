@@ -0,0 +1,123 @@
+package report
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lyyyuna/gococo/pkg/cover"
+)
+
+// testSource is the package shape of module example.com/m
+const testSource = `package shape
+
+func Area(w, h int) int {
+	if w < 0 || h < 0 {
+		return 0
+	}
+	return w * h
+}
+
+func Zero() int {
+	return 0
+}
+`
+
+// testProfile calls Area twice with positive sizes, Zero is never called
+const testProfile = `mode: atomic
+example.com/m/shape/shape.go:4.2,4.20 1 2
+example.com/m/shape/shape.go:5.3,6.1 1 0
+example.com/m/shape/shape.go:7.2,7.14 1 2
+example.com/m/shape/shape.go:11.2,12.1 1 0
+`
+
+// newTestReport writes the source of module example.com/m to a temporary directory,
+// and returns the report of the profile resolved against it.
+func newTestReport(t *testing.T, profile string) (*Report, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "shape"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "shape", "shape.go"), []byte(testSource), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ps, err := cover.ParseProfiles(strings.NewReader(profile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resolver := &Resolver{
+		dir:     dir,
+		modules: []module{{Path: "example.com/m", Dir: dir}},
+	}
+
+	return NewReport(ps, resolver), dir
+}
+
+func TestWriteLCOV(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile string
+		want    string
+	}{
+		{
+			name:    "resolved file with functions",
+			profile: testProfile,
+			want: `TN:
+SF:{{dir}}/shape/shape.go
+FN:3,Area
+FN:10,Zero
+FNDA:2,Area
+FNDA:0,Zero
+FNF:2
+FNH:1
+DA:4,2
+DA:5,0
+DA:7,2
+DA:11,0
+LF:4
+LH:2
+end_of_record
+`,
+		},
+		{
+			name:    "source not found",
+			profile: "mode: set\nexample.com/m/gone.go:1.1,3.1 2 1\nexample.com/m/gone.go:4.5,4.20 1 0\n",
+			want: `TN:
+SF:example.com/m/gone.go
+DA:1,1
+DA:2,1
+DA:4,0
+LF:3
+LH:2
+end_of_record
+`,
+		},
+		{
+			name:    "empty",
+			profile: "mode: atomic\n",
+			want:    "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, dir := newTestReport(t, tt.profile)
+
+			var buf bytes.Buffer
+			if err := r.WriteLCOV(&buf); err != nil {
+				t.Fatal(err)
+			}
+
+			want := strings.ReplaceAll(tt.want, "{{dir}}/shape/shape.go", filepath.Join(dir, "shape", "shape.go"))
+			if got := buf.String(); got != want {
+				t.Errorf("got:\n%v\nwant:\n%v", got, want)
+			}
+		})
+	}
+}
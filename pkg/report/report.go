@@ -0,0 +1,129 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/lyyyuna/gococo/pkg/cover"
+)
+
+// Report is the statement coverage summary of the profiles
+type Report struct {
+	Packages []*PackageSummary
+	Summary
+
+	resolver *Resolver
+}
+
+// Summary counts the statements
+type Summary struct {
+	Statements int
+	Covered    int
+}
+
+// Percent returns the statement coverage in percent
+func (s Summary) Percent() float64 {
+	if s.Statements == 0 {
+		return 0
+	}
+
+	return float64(s.Covered) / float64(s.Statements) * 100
+}
+
+// PackageSummary is the coverage summary of a package
+type PackageSummary struct {
+	ImportPath string
+	Files      []*FileSummary
+	Summary
+}
+
+// FileSummary is the coverage summary of a file
+type FileSummary struct {
+	// FileName is the file name in the profile
+	FileName string
+	// Path is the resolved source file path, empty if not resolved
+	Path    string
+	Profile *cover.Profile
+	Summary
+}
+
+// NewReport summarizes the profiles, the resolver can be nil
+func NewReport(profiles []*cover.Profile, resolver *Resolver) *Report {
+	r := &Report{
+		resolver: resolver,
+	}
+
+	pkgs := make(map[string]*PackageSummary)
+	for _, p := range profiles {
+		f := &FileSummary{
+			FileName: p.FileName,
+			Profile:  p,
+		}
+		if src, ok := resolver.Resolve(p.FileName); ok {
+			f.Path = src
+		}
+		for _, b := range p.Blocks {
+			f.Statements += b.NumStmt
+			if b.Count > 0 {
+				f.Covered += b.NumStmt
+			}
+		}
+
+		importPath := path.Dir(p.FileName)
+		pkg := pkgs[importPath]
+		if pkg == nil {
+			pkg = &PackageSummary{
+				ImportPath: importPath,
+			}
+			pkgs[importPath] = pkg
+			r.Packages = append(r.Packages, pkg)
+		}
+		pkg.Files = append(pkg.Files, f)
+		pkg.Statements += f.Statements
+		pkg.Covered += f.Covered
+
+		r.Statements += f.Statements
+		r.Covered += f.Covered
+	}
+
+	sort.Slice(r.Packages, func(i, j int) bool {
+		return r.Packages[i].ImportPath < r.Packages[j].ImportPath
+	})
+	for _, pkg := range r.Packages {
+		sort.Slice(pkg.Files, func(i, j int) bool {
+			return pkg.Files[i].FileName < pkg.Files[j].FileName
+		})
+	}
+
+	return r
+}
+
+// WriteText writes the per-package and per-file statement coverage as a table
+func (r *Report) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "PACKAGE\tSTATEMENTS\tCOVERED\tCOVERAGE\n")
+	for _, pkg := range r.Packages {
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%.1f%%\n", pkg.ImportPath, pkg.Statements, pkg.Covered, pkg.Percent())
+	}
+	fmt.Fprintf(tw, "\n")
+
+	fmt.Fprintf(tw, "FILE\tSTATEMENTS\tCOVERED\tCOVERAGE\n")
+	for _, pkg := range r.Packages {
+		for _, f := range pkg.Files {
+			name := f.FileName
+			if f.Path != "" {
+				name = f.Path
+			}
+			fmt.Fprintf(tw, "%v\t%v\t%v\t%.1f%%\n", name, f.Statements, f.Covered, f.Percent())
+		}
+	}
+	fmt.Fprintf(tw, "\n")
+
+	fmt.Fprintf(tw, "TOTAL\t%v\t%v\t%.1f%%\n", r.Statements, r.Covered, r.Percent())
+
+	return tw.Flush()
+}
@@ -0,0 +1,101 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Resolver resolves the file names in the profile, like `github.com/x/y/pkg/a.go`,
+// to the source files in the original project, not the copy in the gococo cache.
+type Resolver struct {
+	// dir is the absolute path of the project directory
+	dir string
+
+	// modules are sorted by path in descending order, so the longest prefix matches first
+	modules []module
+}
+
+type module struct {
+	Path string
+	Dir  string
+}
+
+// NewResolver uses `go list -m -json` in the directory to find the main modules
+func NewResolver(dir string) (*Resolver, error) {
+	cmd := exec.Command("go", "list", "-m", "-json")
+	cmd.Dir = dir
+
+	var errBuf bytes.Buffer
+	cmd.Stderr = &errBuf
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("execute go list -m -json failed, err: %v, stderr: %v", err, errBuf.String())
+	}
+
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Resolver{
+		dir: absDir,
+	}
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for {
+		var m module
+		if err := dec.Decode(&m); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, fmt.Errorf("reading go list output error: %v", err)
+		}
+		if m.Dir != "" {
+			r.modules = append(r.modules, m)
+		}
+	}
+
+	sort.Slice(r.modules, func(i, j int) bool {
+		return r.modules[i].Path > r.modules[j].Path
+	})
+
+	return r, nil
+}
+
+// Resolve returns the path of the source file, the second return value is false if not found,
+// e.g., the file is removed or renamed after the profile is collected.
+func (r *Resolver) Resolve(fileName string) (string, bool) {
+	if r == nil {
+		return "", false
+	}
+
+	for _, m := range r.modules {
+		if fileName == m.Path {
+			continue
+		}
+		if rel := strings.TrimPrefix(fileName, m.Path+"/"); rel != fileName {
+			src := filepath.Join(m.Dir, filepath.FromSlash(rel))
+			if info, err := os.Stat(src); err != nil || !info.Mode().IsRegular() {
+				return "", false
+			}
+			return src, true
+		}
+	}
+
+	return "", false
+}
+
+// Dir returns the absolute path of the project directory
+func (r *Resolver) Dir() string {
+	if r == nil {
+		return ""
+	}
+
+	return r.dir
+}
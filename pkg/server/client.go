@@ -0,0 +1,76 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client talks to the gococo server
+type Client struct {
+	addr       string
+	httpClient *http.Client
+}
+
+// NewClient creates a client for the server listening on addr,
+// the addr can be either `host:port` or a http url.
+func NewClient(addr string, timeout time.Duration) *Client {
+	if !strings.HasPrefix(addr, "http://") && !strings.HasPrefix(addr, "https://") {
+		addr = "http://" + addr
+	}
+
+	return &Client{
+		addr: strings.TrimSuffix(addr, "/"),
+		httpClient: &http.Client{
+			Timeout: timeout,
+		},
+	}
+}
+
+// Profile collects the merged profile of the instances selected by service names and agent addresses
+func (c *Client) Profile(ctx context.Context, services []string, addrs []string) (*CollectResponse, error) {
+	q := url.Values{}
+	for _, s := range services {
+		q.Add("service", s)
+	}
+	for _, a := range addrs {
+		q.Add("address", a)
+	}
+
+	api := PROFILE_API
+	if len(q) != 0 {
+		api += "?" + q.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.addr+api, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("fail to read the response of %v: %w", PROFILE_API, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %v returns %v: %v", PROFILE_API, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var out CollectResponse
+	if err := json.Unmarshal(body, &out); err != nil {
+		return nil, fmt.Errorf("fail to decode the response: %w", err)
+	}
+
+	return &out, nil
+}
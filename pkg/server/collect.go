@@ -0,0 +1,198 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/lyyyuna/gococo/pkg/agent"
+	"github.com/lyyyuna/gococo/pkg/cover"
+	"github.com/lyyyuna/gococo/pkg/log"
+)
+
+// PROFILE_API collects and merges the profiles of the registered instances
+const PROFILE_API = "/v1/cover/profile"
+
+// DEFAULT_COLLECT_TIMEOUT is the default timeout to collect the profile from one instance
+const DEFAULT_COLLECT_TIMEOUT = 10 * time.Second
+
+// CollectResult is the collection result of one instance
+type CollectResult struct {
+	Instance Instance `json:"instance"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// CollectResponse is the merged profile and the collection result of each instance
+type CollectResponse struct {
+	Profile string          `json:"profile"`
+	Results []CollectResult `json:"results"`
+}
+
+// filter selects the instances by service name or agent address,
+// an empty filter selects all the instances.
+type filter struct {
+	names map[string]struct{}
+	addrs map[string]struct{}
+}
+
+func newFilter(names []string, addrs []string) *filter {
+	f := &filter{
+		names: make(map[string]struct{}),
+		addrs: make(map[string]struct{}),
+	}
+	for _, n := range names {
+		f.names[n] = struct{}{}
+	}
+	for _, a := range addrs {
+		f.addrs[a] = struct{}{}
+	}
+
+	return f
+}
+
+func (f *filter) match(ins Instance) bool {
+	if len(f.names) != 0 {
+		if _, ok := f.names[ins.Name]; !ok {
+			return false
+		}
+	}
+
+	if len(f.addrs) != 0 {
+		if _, ok := f.addrs[ins.Addr]; !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// collect pulls the profiles from the selected instances concurrently and merges them.
+//
+// the failure of one instance, to pull or to merge, does not fail the whole collection,
+// it is reported in the result of the instance.
+func (s *Server) collect(ctx context.Context, f *filter, timeout time.Duration) (*CollectResponse, error) {
+	instances := make([]Instance, 0)
+	for _, ins := range s.registry.list() {
+		if f.match(ins) {
+			instances = append(instances, ins)
+		}
+	}
+
+	results := make([]CollectResult, len(instances))
+	profiles := make([][]*cover.Profile, len(instances))
+
+	var wg sync.WaitGroup
+	for i, ins := range instances {
+		wg.Add(1)
+		go func(i int, ins Instance) {
+			defer wg.Done()
+
+			results[i].Instance = ins
+
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			raw, err := agent.NewClient(ins.Addr, timeout).Profile(ctx)
+			if err != nil {
+				results[i].Error = err.Error()
+				return
+			}
+
+			ps, err := cover.ParseProfiles(bytes.NewReader(raw))
+			if err != nil {
+				results[i].Error = "invalid profile: " + err.Error()
+				return
+			}
+			profiles[i] = ps
+		}(i, ins)
+	}
+	wg.Wait()
+
+	// the instances built from the same source have the same blocks, only the largest group
+	// is merged, the others, e.g., built from another revision, are reported and left out.
+	groups := make(map[string]int)
+	signatures := make([]string, len(profiles))
+	major := ""
+	for i, ps := range profiles {
+		if ps == nil {
+			continue
+		}
+
+		signatures[i] = cover.Signature(ps)
+		groups[signatures[i]]++
+		if major == "" || groups[signatures[i]] > groups[major] {
+			major = signatures[i]
+		}
+	}
+
+	selected := make([][]*cover.Profile, 0, groups[major])
+	for i, ps := range profiles {
+		if ps == nil {
+			continue
+		}
+
+		if signatures[i] != major {
+			results[i].Error = fmt.Sprintf("the profile conflicts with the %v instances merged, built from another source", groups[major])
+			continue
+		}
+		selected = append(selected, ps)
+	}
+
+	merged, err := cover.MergeProfiles(selected...)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := cover.WriteProfiles(&buf, merged); err != nil {
+		return nil, err
+	}
+
+	return &CollectResponse{
+		Profile: buf.String(),
+		Results: results,
+	}, nil
+}
+
+// profileHandler serves the merged profile, the instances can be selected by
+//
+//	?service=name1&service=name2&address=host:port
+//
+// and the timeout for each instance can be set by `?timeout=5s`.
+func (s *Server) profileHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	timeout := s.collectTimeout
+	if t := q.Get("timeout"); t != "" {
+		d, err := time.ParseDuration(t)
+		if err != nil || d <= 0 {
+			http.Error(w, "invalid timeout: "+t, http.StatusBadRequest)
+			return
+		}
+		timeout = d
+	}
+
+	resp, err := s.collect(r.Context(), newFilter(q["service"], q["address"]), timeout)
+	if err != nil {
+		http.Error(w, "fail to collect the profiles: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	failed := 0
+	for _, res := range resp.Results {
+		if res.Error != "" {
+			failed++
+			log.Warnf("fail to collect the profile from %v (%v): %v", res.Instance.ID, res.Instance.Addr, res.Error)
+		}
+	}
+	log.Infof("profile collected from %v instances, %v failed", len(resp.Results), failed)
+
+	writeJSON(w, resp)
+}
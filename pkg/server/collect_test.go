@@ -0,0 +1,73 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newAgent starts a fake agent serving the profile, and registers it with the server
+func newAgent(t *testing.T, s *Server, name string, profile string) string {
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, profile)
+	}))
+	t.Cleanup(agent.Close)
+
+	addr := strings.TrimPrefix(agent.URL, "http://")
+	s.registry.register(Instance{Name: name, Addr: addr})
+
+	return addr
+}
+
+func TestCollectSkipsConflictingInstance(t *testing.T) {
+	tests := []struct {
+		name    string
+		badName string
+		bad     string
+	}{
+		// built from another revision, the statement number differs
+		{"sorted last", "svc-c", "mode: atomic\nmain.go:1.1,3.2 3 5\n"},
+		{"sorted first", "svc-0", "mode: atomic\nmain.go:1.1,3.2 3 5\n"},
+		// the blocks do not overlap the others, but they are still of another revision
+		{"other blocks", "svc-0", "mode: atomic\nmain.go:1.1,2.2 1 5\nmain.go:4.1,5.2 1 1\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer()
+
+			newAgent(t, s, "svc-a", "mode: atomic\nmain.go:1.1,3.2 2 1\nmain.go:4.1,5.2 1 0\n")
+			newAgent(t, s, "svc-b", "mode: atomic\nmain.go:1.1,3.2 2 2\nmain.go:4.1,5.2 1 1\n")
+			bad := newAgent(t, s, tt.badName, tt.bad)
+
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, PROFILE_API, nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %v, body: %v", rec.Code, rec.Body.String())
+			}
+
+			var resp CollectResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+
+			want := "mode: atomic\nmain.go:1.1,3.2 2 3\nmain.go:4.1,5.2 1 1\n"
+			if resp.Profile != want {
+				t.Errorf("profile = %q, want %q", resp.Profile, want)
+			}
+
+			if len(resp.Results) != 3 {
+				t.Fatalf("got %v results, want 3", len(resp.Results))
+			}
+			for _, res := range resp.Results {
+				failed := res.Error != ""
+				if failed != (res.Instance.Addr == bad) {
+					t.Errorf("instance %v (%v): error = %q", res.Instance.Name, res.Instance.Addr, res.Error)
+				}
+			}
+		})
+	}
+}
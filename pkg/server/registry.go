@@ -0,0 +1,108 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Instance is a running instrumented binary registered to the server
+type Instance struct {
+	ID            string    `json:"id"`
+	Name          string    `json:"name"`
+	Host          string    `json:"host"`
+	Pid           int       `json:"pid"`
+	Addr          string    `json:"addr"`
+	RegisteredAt  time.Time `json:"registeredAt"`
+	LastHeartbeat time.Time `json:"lastHeartbeat"`
+}
+
+// registry keeps the live instances, the instances which do not
+// send heartbeat within the ttl are evicted.
+type registry struct {
+	mu        sync.RWMutex
+	instances map[string]*Instance
+	ttl       time.Duration
+}
+
+func newRegistry(ttl time.Duration) *registry {
+	return &registry{
+		instances: make(map[string]*Instance),
+		ttl:       ttl,
+	}
+}
+
+// register adds a new instance, and returns the registered copy with id
+func (r *registry) register(ins Instance) Instance {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	ins.ID = newInstanceID()
+	ins.RegisteredAt = now
+	ins.LastHeartbeat = now
+	r.instances[ins.ID] = &ins
+
+	return ins
+}
+
+// heartbeat refreshes the instance, returns false if the instance is unknown
+func (r *registry) heartbeat(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ins, ok := r.instances[id]
+	if !ok {
+		return false
+	}
+	ins.LastHeartbeat = time.Now()
+
+	return true
+}
+
+// list returns the copy of all the live instances, sorted by name and address
+func (r *registry) list() []Instance {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	out := make([]Instance, 0, len(r.instances))
+	for _, ins := range r.instances {
+		out = append(out, *ins)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Name != out[j].Name {
+			return out[i].Name < out[j].Name
+		}
+		return out[i].Addr < out[j].Addr
+	})
+
+	return out
+}
+
+// evict removes the instances whose last heartbeat is older than the ttl
+func (r *registry) evict(now time.Time) []Instance {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	evicted := make([]Instance, 0)
+	for id, ins := range r.instances {
+		if now.Sub(ins.LastHeartbeat) > r.ttl {
+			evicted = append(evicted, *ins)
+			delete(r.instances, id)
+		}
+	}
+
+	return evicted
+}
+
+func newInstanceID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(b)
+}
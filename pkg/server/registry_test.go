@@ -0,0 +1,98 @@
+package server
+
+import (
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestRegistryEvict(t *testing.T) {
+	const ttl = 30 * time.Second
+	now := time.Now()
+
+	tests := []struct {
+		name        string
+		ages        map[string]time.Duration // instance name -> time since the last heartbeat
+		wantEvicted []string
+	}{
+		{
+			name: "all alive",
+			ages: map[string]time.Duration{"a": 0, "b": ttl / 2},
+		},
+		{
+			name:        "expired",
+			ages:        map[string]time.Duration{"a": ttl / 3, "b": ttl + time.Second, "c": 2 * ttl},
+			wantEvicted: []string{"b", "c"},
+		},
+		{
+			name: "exactly the ttl",
+			ages: map[string]time.Duration{"a": ttl},
+		},
+		{
+			name: "empty",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newRegistry(ttl)
+			for name, age := range tt.ages {
+				ins := r.register(Instance{Name: name, Addr: name + ":7777"})
+				r.instances[ins.ID].LastHeartbeat = now.Add(-age)
+			}
+
+			evicted := make([]string, 0)
+			for _, ins := range r.evict(now) {
+				evicted = append(evicted, ins.Name)
+			}
+			sort.Strings(evicted)
+			if !equalStrings(evicted, tt.wantEvicted) {
+				t.Errorf("evicted %v, want %v", evicted, tt.wantEvicted)
+			}
+
+			if got, want := len(r.list()), len(tt.ages)-len(tt.wantEvicted); got != want {
+				t.Errorf("%v instances left, want %v", got, want)
+			}
+			for _, ins := range r.list() {
+				if !r.heartbeat(ins.ID) {
+					t.Errorf("heartbeat of the live instance %v fails", ins.Name)
+				}
+			}
+		})
+	}
+}
+
+func TestRegistryHeartbeat(t *testing.T) {
+	r := newRegistry(time.Second)
+	ins := r.register(Instance{Name: "a", Addr: "a:7777"})
+
+	// the heartbeat keeps the instance alive
+	r.instances[ins.ID].LastHeartbeat = time.Now().Add(-time.Hour)
+	if !r.heartbeat(ins.ID) {
+		t.Fatalf("heartbeat of the registered instance fails")
+	}
+	if evicted := r.evict(time.Now()); len(evicted) != 0 {
+		t.Errorf("evicted %v after the heartbeat", evicted)
+	}
+
+	// the evicted instance should register again
+	if evicted := r.evict(time.Now().Add(time.Hour)); len(evicted) != 1 {
+		t.Fatalf("evicted %v, want 1", evicted)
+	}
+	if r.heartbeat(ins.ID) {
+		t.Errorf("heartbeat of the evicted instance succeeds")
+	}
+}
+
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,205 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/lyyyuna/gococo/pkg/log"
+)
+
+// the http api of the server
+const (
+	REGISTER_API  = "/v1/agents/register"
+	HEARTBEAT_API = "/v1/agents/heartbeat"
+	LIST_API      = "/v1/agents"
+)
+
+// DEFAULT_TTL is the default time an instance can live without heartbeat
+const DEFAULT_TTL = 30 * time.Second
+
+// MIN_TTL is the minimum ttl, the agents send heartbeat every third of the ttl
+const MIN_TTL = time.Second
+
+// Server is the central registry which the injected agents register with
+type Server struct {
+	registry       *registry
+	ttl            time.Duration
+	collectTimeout time.Duration
+	mux            *http.ServeMux
+}
+
+// Option represents a server option
+type Option func(*Server)
+
+// WithTTL sets the time an instance can live without heartbeat
+func WithTTL(ttl time.Duration) Option {
+	return func(s *Server) {
+		s.ttl = ttl
+	}
+}
+
+// WithCollectTimeout sets the default timeout to collect the profile from one instance
+func WithCollectTimeout(timeout time.Duration) Option {
+	return func(s *Server) {
+		s.collectTimeout = timeout
+	}
+}
+
+// NewServer creates a new server
+func NewServer(opts ...Option) *Server {
+	s := &Server{
+		ttl:            DEFAULT_TTL,
+		collectTimeout: DEFAULT_COLLECT_TIMEOUT,
+		mux:            http.NewServeMux(),
+	}
+
+	for _, o := range opts {
+		o(s)
+	}
+
+	s.registry = newRegistry(s.ttl)
+
+	s.mux.HandleFunc(REGISTER_API, s.registerHandler)
+	s.mux.HandleFunc(HEARTBEAT_API, s.heartbeatHandler)
+	s.mux.HandleFunc(LIST_API, s.listHandler)
+	s.mux.HandleFunc(PROFILE_API, s.profileHandler)
+
+	return s
+}
+
+// Run listens on the address and serves until the context is done
+func (s *Server) Run(ctx context.Context, addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	log.Infof("gococo server listening on: %v", ln.Addr())
+
+	srv := &http.Server{Handler: s.mux}
+	go s.evictLoop(ctx)
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+
+	if err := srv.Serve(ln); err != http.ErrServerClosed {
+		return err
+	}
+
+	return nil
+}
+
+// ServeHTTP makes the server a http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// heartbeatInterval is the interval the agents send heartbeat, and the server checks the evictions
+func (s *Server) heartbeatInterval() time.Duration {
+	return s.ttl / 3
+}
+
+func (s *Server) evictLoop(ctx context.Context) {
+	ticker := time.NewTicker(s.heartbeatInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, ins := range s.registry.evict(now) {
+				log.Infof("instance evicted: %v (%v, %v)", ins.ID, ins.Name, ins.Addr)
+			}
+		}
+	}
+}
+
+func (s *Server) registerHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var ins Instance
+	if err := json.NewDecoder(r.Body).Decode(&ins); err != nil {
+		http.Error(w, "invalid instance: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if ins.Addr == "" {
+		http.Error(w, "invalid instance: empty address", http.StatusBadRequest)
+		return
+	}
+
+	ins.Addr = resolveAddr(ins.Addr, r.RemoteAddr)
+	ins = s.registry.register(ins)
+	log.Infof("instance registered: %v (%v, %v)", ins.ID, ins.Name, ins.Addr)
+
+	// the agent sends heartbeat at the interval told by the server, so any ttl works
+	writeJSON(w, struct {
+		Instance
+		HeartbeatInterval time.Duration `json:"heartbeatInterval"`
+	}{ins, s.heartbeatInterval()})
+}
+
+func (s *Server) heartbeatHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid heartbeat: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// the agent should register again when it receives 404
+	if !s.registry.heartbeat(req.ID) {
+		http.Error(w, "unknown instance: "+req.ID, http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) listHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeJSON(w, s.registry.list())
+}
+
+// resolveAddr replaces the unspecified host in the agent address,
+// like `[::]:7777` or `:7777`, with the remote host of the request.
+func resolveAddr(addr string, remoteAddr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+
+	if ip := net.ParseIP(host); host != "" && (ip == nil || !ip.IsUnspecified()) {
+		return addr
+	}
+
+	remoteHost, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return addr
+	}
+
+	return net.JoinHostPort(remoteHost, port)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Errorf("fail to write the response: %v", err)
+	}
+}
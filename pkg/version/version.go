@@ -0,0 +1,6 @@
+package version
+
+// Version is the version of gococo, it can be overridden by
+//
+//	-ldflags "-X github.com/lyyyuna/gococo/pkg/version.Version=x.y.z"
+var Version = "0.0.1"
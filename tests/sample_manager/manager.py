@@ -1,6 +1,9 @@
 import typing
 from .sample import Sample
 from .sample1 import s1
+from .sample2 import s2
+from .sample3 import s3
+from .sample4 import s4
 
 
 class SampleManager():
@@ -11,8 +14,23 @@ class SampleManager():
     def simple_project(self) -> Sample:
         return self._samples["simple_project"]
 
+    @property
+    def cross_platform_project(self) -> Sample:
+        return self._samples["cross_platform_project"]
+
+    @property
+    def platform_main_project(self) -> Sample:
+        return self._samples["platform_main_project"]
+
+    @property
+    def workspace_project(self) -> Sample:
+        return self._samples["workspace_project"]
+
 
 samples: typing.Dict[str, Sample] = {}
 samples["simple_project"] = s1
+samples["cross_platform_project"] = s2
+samples["platform_main_project"] = s3
+samples["workspace_project"] = s4
 
 sm = SampleManager(samples)
\ No newline at end of file
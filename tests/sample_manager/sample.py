@@ -20,5 +20,6 @@ class Sample():
         '''
         for path, content in self.files.items():
             filepath = os.path.join(base, path)
+            os.makedirs(os.path.dirname(filepath), exist_ok=True)
             with open(filepath, 'w+') as f:
                 f.write(content)
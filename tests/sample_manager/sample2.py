@@ -0,0 +1,38 @@
+from . import sample
+
+
+s2 = sample.Sample()
+
+s2.files["./go.mod"] = \
+'''module lyyyuna.com/gococo/test
+
+go 1.20
+'''
+
+s2.files["./main.go"] = \
+'''package main
+
+import "fmt"
+
+func main() {
+    fmt.Println("hello,", name())
+}
+'''
+
+s2.files["./name_windows.go"] = \
+'''package main
+
+func name() string {
+    return "windows"
+}
+'''
+
+s2.files["./name_other.go"] = \
+'''//go:build !windows
+
+package main
+
+func name() string {
+    return "world"
+}
+'''
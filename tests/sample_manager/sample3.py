@@ -0,0 +1,32 @@
+from . import sample
+
+
+s3 = sample.Sample()
+
+s3.files["./go.mod"] = \
+'''module lyyyuna.com/gococo/test
+
+go 1.20
+'''
+
+s3.files["./main_windows.go"] = \
+'''package main
+
+import "fmt"
+
+func main() {
+    fmt.Println("hello, windows")
+}
+'''
+
+s3.files["./main_other.go"] = \
+'''//go:build !windows
+
+package main
+
+import "fmt"
+
+func main() {
+    fmt.Println("hello, world")
+}
+'''
@@ -0,0 +1,47 @@
+from . import sample
+
+
+s4 = sample.Sample()
+
+s4.files["./go.work"] = \
+'''go 1.20
+
+use (
+    ./app
+    ./lib
+)
+'''
+
+s4.files["./app/go.mod"] = \
+'''module lyyyuna.com/gococo/app
+
+go 1.20
+'''
+
+s4.files["./app/main.go"] = \
+'''package main
+
+import (
+    "fmt"
+
+    "lyyyuna.com/gococo/lib"
+)
+
+func main() {
+    fmt.Println("hello,", lib.Name())
+}
+'''
+
+s4.files["./lib/go.mod"] = \
+'''module lyyyuna.com/gococo/lib
+
+go 1.20
+'''
+
+s4.files["./lib/lib.go"] = \
+'''package lib
+
+func Name() string {
+    return "world"
+}
+'''
@@ -1,4 +1,5 @@
 import logging
+import os
 import subprocess
 from sample_manager import sm
 
@@ -8,3 +9,100 @@ def test_basic_build(tmp_path):
     res = subprocess.run(["gococo", "build"], capture_output=True, cwd=tmp_path)
     assert res.returncode == 0
     assert res.stdout.find(b'information parsed') > 0
+
+
+def test_build_output_binary(tmp_path):
+    sm.simple_project.generate(tmp_path)
+    res = subprocess.run(["gococo", "build"], capture_output=True, cwd=tmp_path)
+    assert res.returncode == 0
+
+    binary = tmp_path / ("test.exe" if os.name == "nt" else "test")
+    assert binary.exists()
+
+    res = subprocess.run([str(binary)], capture_output=True)
+    assert res.returncode == 0
+    assert res.stdout.find(b'hello, world') >= 0
+
+
+def test_build_failure_exit_code(tmp_path):
+    sm.simple_project.generate(tmp_path)
+    with open(tmp_path / "bad.go", "w+") as f:
+        f.write("package main\n\nfunc bad( {\n")
+    res = subprocess.run(["gococo", "build"], capture_output=True, cwd=tmp_path)
+    assert res.returncode != 0
+
+
+def test_basic_run(tmp_path):
+    sm.simple_project.generate(tmp_path)
+    res = subprocess.run(["gococo", "run", ".", "--", "arg"], capture_output=True, cwd=tmp_path)
+    assert res.returncode == 0
+    assert res.stdout.find(b'hello, world') >= 0
+
+    profile = tmp_path / "gococo.cov"
+    assert profile.exists()
+    assert profile.read_text().startswith("mode: atomic")
+
+
+def test_build_alternating_goos(tmp_path):
+    sm.cross_platform_project.generate(tmp_path)
+
+    # the files instrumented for one GOOS are restored in the shared cache for the others
+    for goos, backend in [("windows", "source"), ("linux", "native"), ("windows", "native"),
+                          ("linux", "source"), ("windows", "source"), ("linux", "source")]:
+        env = dict(os.environ, GOOS=goos, GOARCH="amd64")
+        res = subprocess.run(["gococo", "build", "--gococo-backend=" + backend, "-o", "out", "."],
+                             capture_output=True, cwd=tmp_path, env=env)
+        assert res.returncode == 0, (goos, backend, res.stdout, res.stderr)
+
+
+def test_build_keeps_original_files(tmp_path):
+    sm.platform_main_project.generate(tmp_path)
+
+    # the go file ignored for one GOOS is built for the other, it must not be linked to the original
+    # with the main package excluded, its files are not instrumented but the main function is still renamed
+    for goos, flags in [("windows", []), ("linux", ["--gococo-backend=native"]),
+                        ("windows", []), ("linux", ["--gococo-cover-exclude=lyyyuna.com/gococo/test"])]:
+        env = dict(os.environ, GOOS=goos, GOARCH="amd64")
+        res = subprocess.run(["gococo", "build"] + flags + ["-o", "out", "."],
+                             capture_output=True, cwd=tmp_path, env=env)
+        assert res.returncode == 0, (goos, flags, res.stdout, res.stderr)
+
+        for path, content in sm.platform_main_project.files.items():
+            with open(os.path.join(tmp_path, path)) as f:
+                assert f.read() == content, (goos, flags, path)
+
+
+def test_build_go_files(tmp_path):
+    sm.simple_project.generate(tmp_path)
+    with open(tmp_path / "unused.go", "w+") as f:
+        f.write("package main\n\nfunc unused() {}\n")
+
+    # the go files not in the command line are not registered
+    res = subprocess.run(["gococo", "build", "main.go"], capture_output=True, cwd=tmp_path)
+    assert res.returncode == 0, (res.stdout, res.stderr)
+
+    binary = tmp_path / ("main.exe" if os.name == "nt" else "main")
+    res = subprocess.run([str(binary)], capture_output=True)
+    assert res.returncode == 0
+    assert res.stdout.find(b'hello, world') >= 0
+
+
+def test_run_workspace_root(tmp_path):
+    sm.workspace_project.generate(tmp_path)
+
+    # the go.work directory is not a module, the members are instrumented
+    res = subprocess.run(["gococo", "run", "./app"], capture_output=True, cwd=tmp_path)
+    assert res.returncode == 0, (res.stdout, res.stderr)
+    assert res.stdout.find(b'hello, world') >= 0
+
+    profile = (tmp_path / "gococo.cov").read_text()
+    assert profile.find("lyyyuna.com/gococo/app/main.go") > 0
+    assert profile.find("lyyyuna.com/gococo/lib/lib.go") > 0
+
+    res = subprocess.run(["gococo", "run", "--gococo-work-modules=lyyyuna.com/gococo/lib", "./app"],
+                         capture_output=True, cwd=tmp_path)
+    assert res.returncode == 0, (res.stdout, res.stderr)
+
+    profile = (tmp_path / "gococo.cov").read_text()
+    assert profile.find("lyyyuna.com/gococo/app/main.go") < 0
+    assert profile.find("lyyyuna.com/gococo/lib/lib.go") > 0